@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kingpin"
+)
+
+const (
+	// The team which owns a volume, recorded as a tag on the EFS Filesystem.
+	optOwner = "owner"
+
+	// The container label which identifies the team of a container.
+	optOwnerLabel = "owner_label"
+)
+
+var (
+	cliOwnerLabel = kingpin.Flag("owner-label", "Container label (eg. com.example.team) which must match the owner of a volume to mount it.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_OWNER_LABEL").String()
+)
+
+// Helper function to check that the containers using a volume belong to the
+// team which owns it. Volumes without an owner can be mounted by anyone.
+func Authorize(label string, o Options, n string) error {
+	owner := o.Get(optOwner, "")
+	if label == "" || owner == "" {
+		return nil
+	}
+
+	// Docker doesn't tell us which container a mount is for, but the
+	// container has been created by the time the volume is mounted. Any
+	// container which uses the volume (even a stopped one) could be the one
+	// being started, so all of them must belong to the owner.
+	containers, err := ContainersUsing(n)
+	if err != nil {
+		return fmt.Errorf("cannot authorize volume %s: %s", n, err)
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("cannot authorize volume %s: no container uses it", n)
+	}
+
+	for _, c := range containers {
+		var team string
+		if c.Config != nil {
+			team = c.Config.Labels[label]
+		}
+		if team != owner {
+			return fmt.Errorf("container %s (%s=%s) cannot mount volume %s owned by %s", c.Name, label, team, n, owner)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const testOwnerLabel = "com.example.team"
+
+func team(t string) map[string]string {
+	return map[string]string{testOwnerLabel: t}
+}
+
+func TestAuthorize(t *testing.T) {
+	type container struct {
+		labels map[string]string
+		binds  []string
+		mounts []ContainerMount
+		state  docker.State
+	}
+
+	tests := []struct {
+		name       string
+		containers []container
+		allowed    bool
+	}{
+		{
+			name:       "-v by the owner",
+			containers: []container{{labels: team("a"), binds: []string{"foo:/data"}, state: starting}},
+			allowed:    true,
+		},
+		{
+			name:       "--mount by the owner",
+			containers: []container{{labels: team("a"), mounts: volumeMount("foo", "efs"), state: starting}},
+			allowed:    true,
+		},
+		{
+			name:       "--mount through the managed plugin by the owner",
+			containers: []container{{labels: team("a"), mounts: volumeMount("foo", "nickschuch/efs:latest"), state: starting}},
+			allowed:    true,
+		},
+		{
+			name:       "-v by another team",
+			containers: []container{{labels: team("b"), binds: []string{"foo:/data"}, state: starting}},
+		},
+		{
+			name:       "--mount by another team",
+			containers: []container{{labels: team("b"), mounts: volumeMount("foo", "efs"), state: starting}},
+		},
+		{
+			name: "--mount by another team while the owner uses it with -v",
+			containers: []container{
+				{labels: team("a"), binds: []string{"foo:/data"}, state: running},
+				{labels: team("b"), mounts: volumeMount("foo", "efs"), state: starting},
+			},
+		},
+		{
+			name:       "--mount without a label",
+			containers: []container{{mounts: volumeMount("foo", "efs"), state: starting}},
+		},
+		{
+			name: "stopped container of another team",
+			containers: []container{
+				{labels: team("b"), binds: []string{"foo:/data"}, state: stopped},
+				{labels: team("a"), mounts: volumeMount("foo", "efs"), state: starting},
+			},
+		},
+		{
+			name: "restarting a stopped container of another team while the owner's is running",
+			containers: []container{
+				{labels: team("a"), binds: []string{"foo:/data"}, state: running},
+				{labels: team("b"), mounts: volumeMount("foo", "efs"), state: stopped},
+			},
+		},
+		{
+			name: "restarting a stopped container of the owner",
+			containers: []container{
+				{labels: team("a"), binds: []string{"foo:/data"}, state: running},
+				{labels: team("a"), mounts: volumeMount("foo", "efs"), state: stopped},
+			},
+			allowed: true,
+		},
+		{
+			name: "restarting a stopped container of another team",
+			containers: []container{
+				{labels: team("a"), binds: []string{"foo:/data"}, state: stopped},
+				{labels: team("b"), mounts: volumeMount("foo", "efs"), state: stopped},
+			},
+		},
+		{
+			name: "volume of another driver with the same name",
+			containers: []container{
+				{labels: team("b"), mounts: volumeMount("foo", "local"), state: running},
+				{labels: team("a"), mounts: volumeMount("foo", "efs"), state: starting},
+			},
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDocker(t)
+			for _, c := range tt.containers {
+				f.create("", c.labels, c.binds, c.mounts, c.state)
+			}
+
+			err := Authorize(testOwnerLabel, Options{optOwner: "a"}, "foo")
+			if tt.allowed && err != nil {
+				t.Errorf("denied: %s", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("allowed")
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutOwner(t *testing.T) {
+	// Nothing is looked up (there is no Docker daemon to ask).
+	if err := Authorize(testOwnerLabel, Options{}, "foo"); err != nil {
+		t.Error(err)
+	}
+	if err := Authorize("", Options{optOwner: "a"}, "foo"); err != nil {
+		t.Error(err)
+	}
+}
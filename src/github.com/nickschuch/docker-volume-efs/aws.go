@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/service"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+var (
+	// EFS clients, keyed by region and role, so they are only set up once.
+	efsClients   = make(map[string]*efs.EFS)
+	efsClientsMu sync.Mutex
+)
+
+// Helper function to get an EFS client which logs and records metrics for
+// every API call. The volume options determine the credentials (eg. an
+// assumed role).
+func NewEFS(region string, o Options, l *log.Entry) *efs.EFS {
+	key := strings.Join([]string{region, o.Get(optRoleArn, ""), o.Get(optExternalId, "")}, "|")
+
+	efsClientsMu.Lock()
+	base, ok := efsClients[key]
+	if !ok {
+		base = efs.New(&aws.Config{
+			Region:      aws.String(region),
+			Credentials: VolumeCredentials(o),
+		})
+		LimitRequests(base.Service)
+		efsClients[key] = base
+	}
+	efsClientsMu.Unlock()
+
+	// Each caller gets its own handlers so API calls are logged against the
+	// request which made them.
+	s := *base.Service
+	s.Handlers = base.Handlers.Copy()
+	InstrumentRequests(&s, l)
+	return &efs.EFS{Service: &s}
+}
+
+// Helper function to get an EC2 client which logs and records metrics for
+// every API call.
+func NewEC2(region string, l *log.Entry) *ec2.EC2 {
+	e := ec2.New(&aws.Config{Region: aws.String(region)})
+	LimitRequests(e.Service)
+	InstrumentRequests(e.Service, l)
+	return e
+}
+
+// Helper function to log and record the outcome, AWS request ID and duration
+// of each API call made by a service client.
+func InstrumentRequests(s *service.Service, l *log.Entry) {
+	s.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		observeRequest(r, nil)
+		requestFields(r, l).Debug("AWS API call")
+	})
+	s.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error == nil {
+			return
+		}
+		observeRequest(r, r.Error)
+		requestFields(r, l).WithField("error", r.Error).Warn("AWS API call failed")
+	})
+}
+
+func observeRequest(r *request.Request, err error) {
+	metricAWSCalls.Inc(r.Service.ServiceName, r.Operation.Name, Result(err))
+	metricAWSDuration.Since(r.Time, r.Service.ServiceName, r.Operation.Name)
+}
+
+func requestFields(r *request.Request, l *log.Entry) *log.Entry {
+	return l.WithFields(log.Fields{
+		"aws_service":    r.Service.ServiceName,
+		"aws_operation":  r.Operation.Name,
+		"aws_request_id": r.RequestID,
+		"aws_retries":    r.RetryCount,
+		"duration":       time.Since(r.Time).String(),
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// Volumes can be enrolled in AWS Backup: the automatic backup policy of the
+// EFS Filesystem is enabled (so the default backup plan covers it) and it is
+// tagged with the tag our backup plans select resources by.
+
+const (
+	// Enrol the volume in AWS Backup (enabled) or take it out (disabled).
+	optBackup = "backup"
+
+	backupEnabled  = "enabled"
+	backupDisabled = "disabled"
+)
+
+var (
+	cliAWSBackupTag = kingpin.Flag("aws-backup-tag", "Tag (key=value) which AWS Backup plans select volumes with backup=enabled by.").Default("backup=daily").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_AWS_BACKUP_TAG").String()
+
+	cmdBackupCoverage          = cmdBackup.Command("coverage", "Report which volumes are enrolled in AWS Backup.")
+	cliBackupCoverageUncovered = cmdBackupCoverage.Flag("uncovered", "Only report volumes without backup coverage.").Bool()
+)
+
+// Helper function to validate the backup option. Returns the status of the
+// backup policy, or an empty string if the option isn't set.
+func BackupPolicyStatus(o Options) (string, error) {
+	switch v := o.Get(optBackup, ""); v {
+	case "":
+		return "", nil
+	case backupEnabled:
+		return "ENABLED", nil
+	case backupDisabled:
+		return "DISABLED", nil
+	default:
+		return "", fmt.Errorf("invalid %s: %s (%s, %s)", optBackup, v, backupEnabled, backupDisabled)
+	}
+}
+
+// Helper function to split the AWS Backup selection tag into its key and
+// value.
+func AWSBackupTag() (string, string, error) {
+	parts := strings.SplitN(*cliAWSBackupTag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid --aws-backup-tag: %s (key=value)", *cliAWSBackupTag)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Helper function to set the backup policy and selection tag of an EFS
+// Filesystem from the volume options. Does nothing if the option isn't set.
+func EnrollBackup(e *efs.EFS, id string, o Options) error {
+	status, err := BackupPolicyStatus(o)
+	if err != nil || status == "" {
+		return err
+	}
+	key, value, err := AWSBackupTag()
+	if err != nil {
+		return err
+	}
+
+	err = putBackupPolicy(e, &putBackupPolicyInput{
+		FileSystemId: aws.String(id),
+		BackupPolicy: &backupPolicy{Status: aws.String(status)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if status == "DISABLED" {
+		_, err := e.DeleteTags(&efs.DeleteTagsInput{
+			FileSystemId: aws.String(id),
+			TagKeys:      []*string{aws.String(key)},
+		})
+		return err
+	}
+	return TagFilesystem(e, id, []*efs.Tag{
+		{Key: aws.String(key), Value: aws.String(value)},
+	})
+}
+
+// Helper function to determine if an EFS Filesystem is covered by AWS Backup,
+// by its backup policy or by the selection tag.
+func BackupCoverage(e *efs.EFS, id string) (bool, bool, error) {
+	key, value, err := AWSBackupTag()
+	if err != nil {
+		return false, false, err
+	}
+
+	policy := false
+	resp, err := describeBackupPolicy(e, &describeBackupPolicyInput{
+		FileSystemId: aws.String(id),
+	})
+	if err == nil && resp.BackupPolicy != nil {
+		status := aws.StringValue(resp.BackupPolicy.Status)
+		policy = status == "ENABLED" || status == "ENABLING"
+	} else if err != nil && !IsErrorCode(err, "PolicyNotFound") {
+		return false, false, err
+	}
+
+	tags, err := DescribeTags(e, id)
+	if err != nil {
+		return false, false, err
+	}
+	tagged := false
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) == value {
+			tagged = true
+		}
+	}
+	return policy, tagged, nil
+}
+
+// BackupCoverageReport prints whether each volume is covered by AWS Backup.
+func BackupCoverageReport(d DriverEFS) error {
+	l := log.WithField("command", cmdBackupCoverage.FullCommand())
+	if _, _, err := AWSBackupTag(); err != nil {
+		return err
+	}
+
+	var names []string
+	for n := range d.KnownVolumes(l) {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VOLUME\tFILESYSTEM\tPOLICY\tTAG\tCOVERED")
+	uncovered := 0
+	for _, n := range names {
+		e, fs, _, err := d.Lookup(l, n)
+		if err != nil || fs == nil {
+			l.WithFields(log.Fields{
+				"volume": n,
+				"error":  err,
+			}).Warn("Cannot find EFS Filesystem")
+			continue
+		}
+
+		policy, tagged, err := BackupCoverage(e, *fs.FileSystemId)
+		if err != nil {
+			return err
+		}
+		covered := policy || tagged
+		if !covered {
+			uncovered++
+		} else if *cliBackupCoverageUncovered {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%t\n", n, *fs.FileSystemId, policy, tagged, covered)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	l.WithFields(log.Fields{
+		"volumes":   len(names),
+		"uncovered": uncovered,
+	}).Info("Reported backup coverage")
+	return nil
+}
@@ -0,0 +1,904 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+)
+
+// Backups are stored in an S3 compatible bucket. Files are split into chunks
+// which are compressed and stored by the hash of their contents, so a chunk
+// is only uploaded once no matter how many files, volumes or backups contain
+// it. Each backup has a manifest listing its files and their chunks, and
+// files which haven't changed (by size and modification time) since the
+// previous backup reuse its chunks without being read.
+//
+//   <prefix>/chunks/<hash[:2]>/<hash>
+//   <prefix>/volumes/<volume>/<id>.json.gz
+//
+// A backup may reuse chunks which no finished backup uses (eg. after its
+// previous backup was pruned), so backups and prunes record that they are
+// running. A prune only deletes chunks while no backup is pending, and a
+// backup waits for the prunes which are running before it starts.
+//
+//   <prefix>/pending/<volume>/<id>.json.gz
+//   <prefix>/pruning/<volume>-<id>
+
+const (
+	// Backups are identified by when they started, to the nanosecond so
+	// backups started in the same second (eg. on two hosts) don't collide.
+	// Backups made before had IDs to the second, which backupIDLayout parses.
+	backupIDFormat       = "20060102T150405.000000000Z"
+	backupIDLayout       = "20060102T150405Z"
+	backupManifestSuffix = ".json.gz"
+
+	// Unreferenced chunks younger than this aren't pruned, as they might
+	// belong to a backup which is still running. Records of running backups
+	// and prunes older than this were left by runs which didn't finish.
+	backupChunkGrace = 24 * time.Hour
+)
+
+var (
+	cliBackupEndpoint  = kingpin.Flag("backup-endpoint", "S3 compatible endpoint to store backups in (defaults to AWS S3 in --backup-region).").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_ENDPOINT").String()
+	cliBackupRegion    = kingpin.Flag("backup-region", "Region of the backup bucket (defaults to the region of this host).").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_REGION").String()
+	cliBackupBucket    = kingpin.Flag("backup-bucket", "Bucket to store backups in.").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_BUCKET").String()
+	cliBackupPrefix    = kingpin.Flag("backup-prefix", "Prefix of the backup objects in the bucket.").Default("docker-volume-efs").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_PREFIX").String()
+	cliBackupAccessKey = kingpin.Flag("backup-access-key", "Access key for the backup bucket (defaults to the AWS credentials of this host).").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_ACCESS_KEY").String()
+	cliBackupSecretKey = kingpin.Flag("backup-secret-key", "Secret key for the backup bucket.").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_SECRET_KEY").String()
+	cliBackupChunkSize = kingpin.Flag("backup-chunk-size", "Size of the chunks files are split into, in MiB.").Default("8").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_BACKUP_CHUNK_SIZE").Int()
+
+	cmdBackup = kingpin.Command("backup", "Manage volume backups in an S3 compatible bucket.")
+
+	cmdBackupCreate        = cmdBackup.Command("create", "Back up a volume.")
+	cliBackupCreateVolume  = cmdBackupCreate.Arg("volume", "Volume to back up.").Required().String()
+	cliBackupCreatePause   = cmdBackupCreate.Flag("pause", "Pause the containers using the volume while it is backed up.").Bool()
+	cliBackupCreateWorkers = cmdBackupCreate.Flag("workers", "How many files to back up at once.").Default("8").Int()
+
+	cmdBackupList       = cmdBackup.Command("list", "List the backups of a volume.")
+	cliBackupListVolume = cmdBackupList.Arg("volume", "Volume to list the backups of.").Required().String()
+
+	cmdBackupRestore        = cmdBackup.Command("restore", "Restore a backup into a volume.")
+	cliBackupRestoreVolume  = cmdBackupRestore.Arg("volume", "Volume the backup is of.").Required().String()
+	cliBackupRestoreID      = cmdBackupRestore.Arg("id", "Backup to restore (defaults to the latest).").String()
+	cliBackupRestoreTo      = cmdBackupRestore.Flag("to", "Volume to restore into (defaults to the volume itself).").String()
+	cliBackupRestorePause   = cmdBackupRestore.Flag("pause", "Pause the containers using the volume while it is restored.").Bool()
+	cliBackupRestoreWorkers = cmdBackupRestore.Flag("workers", "How many files to restore at once.").Default("8").Int()
+	cliBackupRestoreForce   = cmdBackupRestore.Flag("force", "Confirm that the current contents of the volume will be deleted.").Bool()
+
+	cmdBackupPrune           = cmdBackup.Command("prune", "Delete old backups of a volume, and chunks no backup uses.")
+	cliBackupPruneVolume     = cmdBackupPrune.Arg("volume", "Volume to prune the backups of.").Required().String()
+	cliBackupPruneKeep       = cmdBackupPrune.Flag("keep", "How many of the latest backups to keep.").Default("7").Int()
+	cliBackupPruneKeepWithin = cmdBackupPrune.Flag("keep-within", "Also keep backups younger than this (eg. 720h).").Duration()
+
+	// How often a backup checks if the prunes it waits for have finished.
+	backupPollInterval = 10 * time.Second
+)
+
+// BackupManifest describes a backup and the files in it.
+type BackupManifest struct {
+	Volume    string
+	ID        string
+	Parent    string `json:",omitempty"`
+	Created   time.Time
+	Duration  string
+	ChunkSize int64
+	Files     []BackupFile
+}
+
+// BackupFile is a file, directory or symlink in a backup.
+type BackupFile struct {
+	Path    string
+	Mode    os.FileMode
+	Uid     uint32
+	Gid     uint32
+	Size    int64 `json:",omitempty"`
+	ModTime time.Time
+	Link    string   `json:",omitempty"`
+	Chunks  []string `json:",omitempty"`
+
+	// The path of another link to the same file, which has the contents.
+	HardLink string `json:",omitempty"`
+}
+
+// BackupStore stores backups in a bucket.
+type BackupStore struct {
+	S3     *S3
+	Prefix string
+
+	// Chunks known to be in the bucket.
+	known   map[string]bool
+	knownMu sync.Mutex
+}
+
+// Helper function to get the backup store from the flags.
+func (d DriverEFS) backupStore(l *log.Entry) (*BackupStore, error) {
+	if *cliBackupBucket == "" {
+		return nil, fmt.Errorf("--backup-bucket is required")
+	}
+	if *cliBackupChunkSize < 1 {
+		return nil, fmt.Errorf("--backup-chunk-size must be at least 1")
+	}
+
+	region := *cliBackupRegion
+	if region == "" {
+		region = d.Region
+	}
+	return &BackupStore{
+		S3:     NewS3(*cliBackupEndpoint, region, *cliBackupBucket, *cliBackupAccessKey, *cliBackupSecretKey, l),
+		Prefix: strings.Trim(*cliBackupPrefix, "/"),
+		known:  make(map[string]bool),
+	}, nil
+}
+
+func (b *BackupStore) chunkKey(h string) string {
+	return b.Prefix + "/chunks/" + h[:2] + "/" + h
+}
+
+func (b *BackupStore) manifestKey(volume, id string) string {
+	return b.Prefix + "/volumes/" + volume + "/" + id + backupManifestSuffix
+}
+
+func (b *BackupStore) pendingKey(volume, id string) string {
+	return b.Prefix + "/pending/" + volume + "/" + id + backupManifestSuffix
+}
+
+// Helper function to determine if a volume has a backup (finished or
+// pending) with an ID.
+func (b *BackupStore) Exists(volume, id string) (bool, error) {
+	for _, key := range []string{b.manifestKey(volume, id), b.pendingKey(volume, id)} {
+		objects, err := b.S3.List(key)
+		if err != nil {
+			return false, err
+		}
+		for _, o := range objects {
+			if o.Key == key {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Helper function to upload a chunk, unless the bucket already has it.
+// Returns the bytes uploaded.
+func (b *BackupStore) PutChunk(h string, data []byte) (int64, error) {
+	b.knownMu.Lock()
+	known := b.known[h]
+	b.knownMu.Unlock()
+	if known {
+		return 0, nil
+	}
+
+	key := b.chunkKey(h)
+	exists, err := b.S3.Exists(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var uploaded int64
+	if !exists {
+		z, err := compress(data)
+		if err != nil {
+			return 0, err
+		}
+		if err := b.S3.Put(key, z); err != nil {
+			return 0, err
+		}
+		uploaded = int64(len(z))
+	}
+
+	b.knownMu.Lock()
+	b.known[h] = true
+	b.knownMu.Unlock()
+	return uploaded, nil
+}
+
+// Helper function to download a chunk and check its contents.
+func (b *BackupStore) GetChunk(h string) ([]byte, error) {
+	z, err := b.S3.Get(b.chunkKey(h))
+	if err != nil {
+		return nil, err
+	}
+	data, err := decompress(z)
+	if err != nil {
+		return nil, err
+	}
+	if hash(data) != h {
+		return nil, fmt.Errorf("chunk %s is corrupt", h)
+	}
+	return data, nil
+}
+
+// Helper function to get the IDs of the backups of a volume, oldest first.
+func (b *BackupStore) IDs(volume string) ([]string, error) {
+	prefix := b.Prefix + "/volumes/" + volume + "/"
+	objects, err := b.S3.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, prefix)
+		if strings.HasSuffix(name, backupManifestSuffix) && !strings.Contains(name, "/") {
+			ids = append(ids, strings.TrimSuffix(name, backupManifestSuffix))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Helper function to get the manifest of a backup.
+func (b *BackupStore) Manifest(volume, id string) (*BackupManifest, error) {
+	z, err := b.S3.Get(b.manifestKey(volume, id))
+	if IsErrorCode(err, "NoSuchKey") {
+		return nil, fmt.Errorf("backup %s of %s does not exist", id, volume)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := decompress(z)
+	if err != nil {
+		return nil, err
+	}
+
+	var m BackupManifest
+	return &m, json.Unmarshal(data, &m)
+}
+
+// Helper function to get the latest manifest of a volume, or nil if it has
+// no backups.
+func (b *BackupStore) Latest(volume string) (*BackupManifest, error) {
+	ids, err := b.IDs(volume)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return b.Manifest(volume, ids[len(ids)-1])
+}
+
+// Helper function to store the manifest of a backup.
+func (b *BackupStore) PutManifest(m *BackupManifest) error {
+	return b.putManifest(b.manifestKey(m.Volume, m.ID), m)
+}
+
+func (b *BackupStore) putManifest(key string, m *BackupManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	z, err := compress(data)
+	if err != nil {
+		return err
+	}
+	return b.S3.Put(key, z)
+}
+
+// Helper function to record that a backup is pending, once no prune is
+// running. Returns a function which removes the record.
+func (b *BackupStore) Pending(l *log.Entry, m *BackupManifest) (func(), error) {
+	key := b.pendingKey(m.Volume, m.ID)
+	if err := b.putManifest(key, m); err != nil {
+		return nil, err
+	}
+	done := func() {
+		if err := b.S3.Delete(key); err != nil {
+			l.WithField("error", err).Warn("Cannot remove the pending backup record")
+		}
+	}
+
+	for {
+		running, err := b.running(b.Prefix + "/pruning/")
+		if err != nil {
+			done()
+			return nil, err
+		}
+		if !running {
+			return done, nil
+		}
+		l.Info("Waiting for a prune to finish")
+		time.Sleep(backupPollInterval)
+	}
+}
+
+// Helper function to determine if a backup or prune is running, by the
+// records under a prefix.
+func (b *BackupStore) running(prefix string) (bool, error) {
+	objects, err := b.S3.List(prefix)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range objects {
+		if time.Since(o.LastModified) < backupChunkGrace {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BackupCreate backs up a volume.
+func BackupCreate(d DriverEFS) error {
+	volume := *cliBackupCreateVolume
+	l := log.WithField("volume", volume)
+
+	store, err := d.backupStore(l)
+	if err != nil {
+		return err
+	}
+
+	src, unmount, err := d.MountPrivate(l, volume)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	if *cliBackupCreatePause {
+		unpause, err := PauseContainers(volume)
+		if err != nil {
+			return err
+		}
+		defer unpause()
+	}
+
+	_, err = store.Backup(l, volume, src, int64(*cliBackupChunkSize)<<20, *cliBackupCreateWorkers)
+	return err
+}
+
+// Backup backs up the files of a volume in a directory.
+func (b *BackupStore) Backup(l *log.Entry, volume, src string, chunkSize int64, workers int) (*BackupManifest, error) {
+	start := time.Now()
+	m := &BackupManifest{
+		Volume:    volume,
+		ID:        start.UTC().Format(backupIDFormat),
+		Created:   start,
+		ChunkSize: chunkSize,
+	}
+	if exists, err := b.Exists(volume, m.ID); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("backup %s of %s already exists", m.ID, volume)
+	}
+
+	// Chunks aren't pruned while the backup is pending, as it may reuse chunks
+	// which no (finished) backup uses.
+	done, err := b.Pending(l, m)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	prev, err := b.Latest(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files which haven't changed since the previous backup (with the same
+	// chunk size) reuse its chunks.
+	unchanged := make(map[string]BackupFile)
+	if prev != nil {
+		m.Parent = prev.ID
+		if prev.ChunkSize == m.ChunkSize {
+			for _, f := range prev.Files {
+				if f.Mode.IsRegular() && f.HardLink == "" {
+					unchanged[f.Path] = f
+					for _, h := range f.Chunks {
+						b.known[h] = true
+					}
+				}
+			}
+		}
+	}
+
+	// The first path of each file with several (hard) links.
+	links := make(map[uint64]string)
+
+	var changed []int
+	var reused int
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == snapshotDir {
+			return filepath.SkipDir
+		}
+
+		st := info.Sys().(*syscall.Stat_t)
+		f := BackupFile{
+			Path:    rel,
+			Mode:    info.Mode(),
+			Uid:     st.Uid,
+			Gid:     st.Gid,
+			ModTime: info.ModTime(),
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsRegular():
+			f.Size = info.Size()
+			if st.Nlink > 1 {
+				if first, ok := links[st.Ino]; ok {
+					f.HardLink = first
+					break
+				}
+				links[st.Ino] = rel
+			}
+			if old, ok := unchanged[rel]; ok && old.Size == f.Size && old.ModTime.Equal(f.ModTime) {
+				f.Chunks = old.Chunks
+				reused++
+			} else {
+				changed = append(changed, len(m.Files))
+			}
+		case mode&os.ModeSymlink != 0:
+			if f.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !mode.IsDir():
+			// Devices, pipes and sockets aren't backed up.
+			l.WithField("path", rel).Debug("Skipping special file")
+			return nil
+		}
+
+		m.Files = append(m.Files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var uploaded int64
+	err = parallel(workers, len(changed), func(i int) error {
+		f := &m.Files[changed[i]]
+		chunks, n, err := backupFile(b, filepath.Join(src, f.Path), m.ChunkSize)
+		if err != nil {
+			return err
+		}
+		f.Chunks = chunks
+		atomic.AddInt64(&uploaded, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.Duration = time.Since(start).String()
+	if err := b.PutManifest(m); err != nil {
+		return nil, err
+	}
+
+	l.WithFields(log.Fields{
+		"backup":    m.ID,
+		"files":     len(m.Files),
+		"changed":   len(changed),
+		"unchanged": reused,
+		"uploaded":  uploaded,
+		"duration":  m.Duration,
+	}).Info("Created backup")
+	return m, nil
+}
+
+// Helper function to split a file into chunks and upload them. Returns the
+// hashes of the chunks and the bytes uploaded.
+func backupFile(store *BackupStore, p string, size int64) ([]string, int64, error) {
+	in, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer in.Close()
+
+	var chunks []string
+	var uploaded int64
+	buf := make([]byte, size)
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			h := hash(buf[:n])
+			u, err := store.PutChunk(h, buf[:n])
+			if err != nil {
+				return nil, 0, err
+			}
+			chunks = append(chunks, h)
+			uploaded += u
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return chunks, uploaded, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// BackupList prints the backups of a volume.
+func BackupList(d DriverEFS) error {
+	volume := *cliBackupListVolume
+	l := log.WithField("volume", volume)
+	if err := ValidateName(volume); err != nil {
+		return err
+	}
+
+	store, err := d.backupStore(l)
+	if err != nil {
+		return err
+	}
+	ids, err := store.IDs(volume)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tFILES\tBYTES\tPARENT")
+	for _, id := range ids {
+		m, err := store.Manifest(volume, id)
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, f := range m.Files {
+			size += f.Size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", m.ID, m.Created.Format(time.RFC3339), len(m.Files), size, m.Parent)
+	}
+	return w.Flush()
+}
+
+// BackupRestore restores a backup into a volume.
+func BackupRestore(d DriverEFS) error {
+	volume, to := *cliBackupRestoreVolume, *cliBackupRestoreTo
+	if to == "" {
+		to = volume
+	}
+	l := log.WithFields(log.Fields{
+		"volume": volume,
+		"to":     to,
+	})
+
+	store, err := d.backupStore(l)
+	if err != nil {
+		return err
+	}
+
+	id := *cliBackupRestoreID
+	if id == "" {
+		ids, err := store.IDs(volume)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("volume %s has no backups", volume)
+		}
+		id = ids[len(ids)-1]
+	}
+	l = l.WithField("backup", id)
+
+	m, err := store.Manifest(volume, id)
+	if err != nil {
+		return err
+	}
+
+	dst, unmount, err := d.MountPrivateOrCreate(l, to)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	if !EmptyDir(dst, snapshotDir) && !*cliBackupRestoreForce {
+		return fmt.Errorf("restoring into %s deletes its current contents, use --force to confirm", to)
+	}
+
+	if *cliBackupRestorePause {
+		unpause, err := PauseContainers(to)
+		if err != nil {
+			return err
+		}
+		defer unpause()
+	}
+
+	return store.Restore(l, m, dst, *cliBackupRestoreWorkers)
+}
+
+// Helper function to check that a backup can be restored, before anything
+// is deleted: its paths stay in the directory it is restored into, and the
+// bucket has all of its chunks.
+func (b *BackupStore) Verify(m *BackupManifest) error {
+	for _, f := range m.Files {
+		for _, p := range []string{f.Path, f.HardLink} {
+			if p != "" && (filepath.IsAbs(p) || p != filepath.Clean(p) || p == ".." || strings.HasPrefix(p, "../")) {
+				return fmt.Errorf("backup %s of %s has an invalid path: %s", m.ID, m.Volume, p)
+			}
+		}
+	}
+
+	chunks, err := b.S3.List(b.Prefix + "/chunks/")
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool)
+	for _, o := range chunks {
+		stored[filepath.Base(o.Key)] = true
+	}
+	for _, f := range m.Files {
+		for _, h := range f.Chunks {
+			if !stored[h] {
+				return fmt.Errorf("backup %s of %s is missing chunk %s (of %s)", m.ID, m.Volume, h, f.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// Restore replaces the contents of a directory with a backup, once it has
+// been verified.
+func (b *BackupStore) Restore(l *log.Entry, m *BackupManifest, dst string, workers int) error {
+	if err := b.Verify(m); err != nil {
+		return err
+	}
+
+	// Delete everything except the snapshots.
+	files, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Name() == snapshotDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dst, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	// Directories and symlinks first, then the files, then their other links.
+	start := time.Now()
+	var regular, links []BackupFile
+	for _, f := range m.Files {
+		p := filepath.Join(dst, f.Path)
+		switch {
+		case f.HardLink != "":
+			links = append(links, f)
+		case f.Mode.IsDir():
+			if err := os.MkdirAll(p, 0700); err != nil {
+				return err
+			}
+		case f.Mode&os.ModeSymlink != 0:
+			if err := os.Symlink(f.Link, p); err != nil {
+				return err
+			}
+			if err := os.Lchown(p, int(f.Uid), int(f.Gid)); err != nil {
+				return err
+			}
+		default:
+			regular = append(regular, f)
+		}
+	}
+
+	err = parallel(workers, len(regular), func(i int) error {
+		return restoreFile(b, filepath.Join(dst, regular[i].Path), regular[i])
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range links {
+		if err := os.Link(filepath.Join(dst, f.HardLink), filepath.Join(dst, f.Path)); err != nil {
+			return err
+		}
+	}
+
+	// Directories are finished last (deepest first), as restoring into them
+	// would change their timestamps.
+	for i := len(m.Files) - 1; i >= 0; i-- {
+		if f := m.Files[i]; f.Mode.IsDir() {
+			if err := restoreMetadata(filepath.Join(dst, f.Path), f); err != nil {
+				return err
+			}
+		}
+	}
+
+	l.WithFields(log.Fields{
+		"files":    len(m.Files),
+		"duration": time.Since(start).String(),
+	}).Info("Restored backup")
+	return nil
+}
+
+// Helper function to restore a regular file from its chunks. Chunks of zeros
+// are skipped rather than written, so sparse files stay sparse.
+func restoreFile(store *BackupStore, p string, f BackupFile) error {
+	out, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, h := range f.Chunks {
+		data, err := store.GetChunk(h)
+		if err != nil {
+			return err
+		}
+		if bytes.Count(data, []byte{0}) == len(data) {
+			_, err = out.Seek(int64(len(data)), io.SeekCurrent)
+		} else {
+			_, err = out.Write(data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := out.Truncate(f.Size); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return restoreMetadata(p, f)
+}
+
+// Helper function to restore the ownership, mode and modification time of a
+// file or directory.
+func restoreMetadata(p string, f BackupFile) error {
+	// Changing the owner clears the setuid and setgid bits, so it goes first.
+	if err := os.Lchown(p, int(f.Uid), int(f.Gid)); err != nil {
+		return err
+	}
+	if err := os.Chmod(p, f.Mode); err != nil {
+		return err
+	}
+	return os.Chtimes(p, f.ModTime, f.ModTime)
+}
+
+// BackupPrune deletes the backups of a volume which are neither among the
+// latest --keep nor younger than --keep-within, then the chunks which no
+// backup (of any volume) uses.
+func BackupPrune(d DriverEFS) error {
+	volume := *cliBackupPruneVolume
+	l := log.WithField("volume", volume)
+	if err := ValidateName(volume); err != nil {
+		return err
+	}
+	if *cliBackupPruneKeep < 1 {
+		return fmt.Errorf("--keep must be at least 1")
+	}
+
+	store, err := d.backupStore(l)
+	if err != nil {
+		return err
+	}
+	return store.Prune(l, volume, *cliBackupPruneKeep, *cliBackupPruneKeepWithin)
+}
+
+// Prune deletes the backups of a volume which are neither among the latest
+// keep nor younger than keepWithin, then the chunks which no backup uses
+// (unless a backup is pending).
+func (b *BackupStore) Prune(l *log.Entry, volume string, keep int, keepWithin time.Duration) error {
+	ids, err := b.IDs(volume)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if i >= len(ids)-keep {
+			break
+		}
+		if created, err := time.Parse(backupIDLayout, id); err == nil && time.Since(created) < keepWithin {
+			continue
+		}
+		if err := b.S3.Delete(b.manifestKey(volume, id)); err != nil {
+			return err
+		}
+		l.WithField("backup", id).Info("Deleted backup")
+	}
+
+	// Backups which start from now on wait for the prune, so only those which
+	// are already pending can reuse the chunks it would delete.
+	key := b.Prefix + "/pruning/" + volume + "-" + time.Now().UTC().Format(backupIDFormat)
+	if err := b.S3.Put(key, []byte{}); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.S3.Delete(key); err != nil {
+			l.WithField("error", err).Warn("Cannot remove the prune record")
+		}
+	}()
+	pending, err := b.running(b.Prefix + "/pending/")
+	if err != nil {
+		return err
+	}
+	if pending {
+		l.Warn("Not deleting chunks while a backup is pending")
+		return nil
+	}
+
+	// Find the chunks still used by the backups of every volume.
+	used := make(map[string]bool)
+	manifests, err := b.S3.List(b.Prefix + "/volumes/")
+	if err != nil {
+		return err
+	}
+	for _, o := range manifests {
+		z, err := b.S3.Get(o.Key)
+		if err != nil {
+			return err
+		}
+		data, err := decompress(z)
+		if err != nil {
+			return err
+		}
+		var m BackupManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("cannot read %s: %s", o.Key, err)
+		}
+		for _, f := range m.Files {
+			for _, h := range f.Chunks {
+				used[h] = true
+			}
+		}
+	}
+
+	chunks, err := b.S3.List(b.Prefix + "/chunks/")
+	if err != nil {
+		return err
+	}
+	var deleted int
+	var freed int64
+	for _, o := range chunks {
+		if used[filepath.Base(o.Key)] || time.Since(o.LastModified) < backupChunkGrace {
+			continue
+		}
+		if err := b.S3.Delete(o.Key); err != nil {
+			return err
+		}
+		deleted++
+		freed += o.Size
+	}
+
+	l.WithFields(log.Fields{
+		"chunks": deleted,
+		"bytes":  freed,
+	}).Info("Pruned backups")
+	return nil
+}
+
+func hash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	if _, err := z.Write(b); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(b []byte) ([]byte, error) {
+	z, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	return ioutil.ReadAll(z)
+}
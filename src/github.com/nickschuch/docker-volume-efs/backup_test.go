@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// fakeS3 is a fake S3 compatible bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	data     []byte
+	modified time.Time
+}
+
+// Helper function to start a fake S3 API and create a backup store in it.
+func newFakeBackupStore(t *testing.T) (*BackupStore, *fakeS3) {
+	f := &fakeS3{objects: make(map[string]*fakeObject)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	store := &BackupStore{
+		S3:     NewS3(server.URL, "us-east-1", "bucket", "id", "secret", log.WithField("test", t.Name())),
+		Prefix: "backups",
+		known:  make(map[string]bool),
+	}
+	return store, f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/bucket"), "/")
+	if key == "" {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	o, ok := f.objects[key]
+	switch r.Method {
+	case "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = &fakeObject{data: data, modified: time.Now()}
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case "HEAD", "GET":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			}
+			return
+		}
+		if r.Method == "GET" {
+			w.Write(o.data)
+		}
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var result struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []S3Object
+	}
+	for k, o := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			result.Contents = append(result.Contents, S3Object{Key: k, Size: int64(len(o.data)), LastModified: o.modified})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	xml.NewEncoder(w).Encode(result)
+}
+
+// Helper function to make objects look older.
+func (f *fakeS3) age(prefix string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, o := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			o.modified = o.modified.Add(-d)
+		}
+	}
+}
+
+func (f *fakeS3) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[key]
+	return ok
+}
+
+func (f *fakeS3) keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	store, f := newFakeBackupStore(t)
+	l := log.WithField("test", t.Name())
+
+	src := t.TempDir()
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 200)
+	write := func(p string, data []byte) {
+		if err := ioutil.WriteFile(filepath.Join(src, p), data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(src, "dir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	write("dir/file", contents)
+	write("dir/copy", contents)
+	write("empty", nil)
+	if err := os.Link(filepath.Join(src, "dir/file"), filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(src, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	sparse := append(make([]byte, 4096), []byte("end")...)
+	write("sparse", sparse)
+
+	m, err := store.Backup(l, "foo", src, 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := f.keys("backups/pending/"); len(keys) > 0 {
+		t.Errorf("pending records left behind: %v", keys)
+	}
+
+	// The same chunks are only stored once: dir/file and dir/copy are a
+	// repeated chunk and a shorter one, sparse is zeros and a short one.
+	if chunks := f.keys("backups/chunks/"); len(chunks) != 4 {
+		t.Errorf("got %d chunks, want 4", len(chunks))
+	}
+
+	dst := t.TempDir()
+	if err := store.Restore(l, m, dst, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string][]byte{"dir/file": contents, "dir/copy": contents, "link": contents, "empty": {}, "sparse": sparse} {
+		got, err := ioutil.ReadFile(filepath.Join(dst, p))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, want %d", p, len(got), len(want))
+		}
+	}
+
+	file, _ := os.Stat(filepath.Join(dst, "dir/file"))
+	link, _ := os.Stat(filepath.Join(dst, "link"))
+	if !os.SameFile(file, link) {
+		t.Error("link is not a hard link to dir/file")
+	}
+	if copy, _ := os.Stat(filepath.Join(dst, "dir/copy")); os.SameFile(file, copy) {
+		t.Error("dir/copy is a hard link to dir/file")
+	}
+	if file.Mode().Perm() != 0640 {
+		t.Errorf("dir/file: got mode %s, want 0640", file.Mode())
+	}
+	if dir, _ := os.Stat(filepath.Join(dst, "dir")); dir.Mode().Perm() != 0750 {
+		t.Errorf("dir: got mode %s, want 0750", dir.Mode())
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "symlink")); err != nil || target != "dir/file" {
+		t.Errorf("symlink: got %q, %v, want dir/file", target, err)
+	}
+}
+
+func TestBackupRestoreVerifies(t *testing.T) {
+	store, f := newFakeBackupStore(t)
+	l := log.WithField("test", t.Name())
+
+	src := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(src, "file"), []byte("backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := store.Backup(l, "foo", src, 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A backup isn't made with the ID of another.
+	if exists, err := store.Exists("foo", m.ID); err != nil || !exists {
+		t.Errorf("Exists(%s) = %v, %v, want true", m.ID, exists, err)
+	}
+	if exists, err := store.Exists("bar", m.ID); err != nil || exists {
+		t.Errorf("Exists(bar) = %v, %v, want false", exists, err)
+	}
+
+	// Nothing is deleted when the backup can't be restored.
+	dst := t.TempDir()
+	current := filepath.Join(dst, "current")
+	if err := ioutil.WriteFile(current, []byte("current"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	escape := *m
+	escape.Files = append([]BackupFile{{Path: "../escape", Mode: 0644}}, m.Files...)
+	if err := store.Restore(l, &escape, dst, 2); err == nil {
+		t.Error("invalid path: got no error")
+	}
+	for _, key := range f.keys("backups/chunks/") {
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+	}
+	if err := store.Restore(l, m, dst, 2); err == nil {
+		t.Error("missing chunk: got no error")
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("the contents were deleted: %s", err)
+	}
+}
+
+func TestBackupPrune(t *testing.T) {
+	chunk := func(h string) string {
+		return "backups/chunks/" + h[:2] + "/" + h
+	}
+
+	tests := []struct {
+		name    string
+		pending time.Duration
+		pruned  bool
+	}{
+		{name: "no backup pending", pruned: true},
+		{name: "backup pending", pending: time.Minute},
+		{name: "backup which didn't finish", pending: 2 * backupChunkGrace, pruned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, f := newFakeBackupStore(t)
+			l := log.WithField("test", t.Name())
+
+			// The old backup is the only one which uses the old chunk.
+			backups := map[string]string{"20200101T000000Z": "aaaa", "20200102T000000Z": "bbbb"}
+			for id, h := range backups {
+				m := &BackupManifest{Volume: "foo", ID: id, Files: []BackupFile{{Path: "file", Chunks: []string{h}}}}
+				if err := store.PutManifest(m); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.S3.Put(chunk(h), []byte(h)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.S3.Put(chunk("cccc"), []byte("cccc")); err != nil {
+				t.Fatal(err)
+			}
+			f.age(chunk("aaaa"), 2*backupChunkGrace)
+			f.age(chunk("bbbb"), 2*backupChunkGrace)
+
+			if tt.pending > 0 {
+				if err := store.S3.Put("backups/pending/bar/20200103T000000Z.json.gz", []byte{}); err != nil {
+					t.Fatal(err)
+				}
+				f.age("backups/pending/", tt.pending)
+			}
+
+			if err := store.Prune(l, "foo", 1, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			if ids, _ := store.IDs("foo"); len(ids) != 1 || ids[0] != "20200102T000000Z" {
+				t.Errorf("got backups %v, want 20200102T000000Z", ids)
+			}
+			if f.has(chunk("aaaa")) == tt.pruned {
+				t.Errorf("unused chunk pruned: got %t, want %t", !tt.pruned, tt.pruned)
+			}
+			if !f.has(chunk("bbbb")) {
+				t.Error("used chunk was pruned")
+			}
+			if !f.has(chunk("cccc")) {
+				t.Error("unused chunk younger than the grace period was pruned")
+			}
+			if keys := f.keys("backups/pruning/"); len(keys) > 0 {
+				t.Errorf("prune records left behind: %v", keys)
+			}
+		})
+	}
+}
+
+func TestBackupWaitsForPrune(t *testing.T) {
+	store, f := newFakeBackupStore(t)
+	l := log.WithField("test", t.Name())
+
+	interval := backupPollInterval
+	backupPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { backupPollInterval = interval })
+
+	if err := store.S3.Put("backups/pruning/bar-20200101T000000Z", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	src := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(src, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := store.Backup(l, "foo", src, 1024, 1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("backup didn't wait for the prune: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(f.keys("backups/pending/")) != 1 {
+		t.Error("the waiting backup isn't pending, so a prune could delete the chunks it reuses")
+	}
+	if len(f.keys("backups/chunks/")) > 0 {
+		t.Error("backup uploaded chunks while a prune was running")
+	}
+
+	store.S3.Delete("backups/pruning/bar-20200101T000000Z")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backup didn't start once the prune finished")
+	}
+	if ids, _ := store.IDs("foo"); len(ids) != 1 {
+		t.Errorf("got backups %v, want 1", ids)
+	}
+}
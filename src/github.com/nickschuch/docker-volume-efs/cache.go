@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+var (
+	cliMountCacheTTL = kingpin.Flag("mount-cache-ttl", "How long to cache the EFS Filesystem and mount target of a volume (0 disables the cache).").Default("5m").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_MOUNT_CACHE_TTL").Duration()
+)
+
+// MountCache caches the EFS Filesystem of each volume, and the mount target of
+// each filesystem in each availability zone, so that mounting a volume (eg.
+// when many containers start together) doesn't call the EFS API each time.
+// It also caches the description of each volume, so that Get and List don't
+// either.
+type MountCache struct {
+	TTL time.Duration
+
+	mu       sync.Mutex
+	volumes  map[string]cachedVolume
+	targets  map[string]cachedTarget
+	statuses map[string]cachedStatus
+}
+
+// VolumeStatus is the description of an existing volume.
+type VolumeStatus struct {
+	FileSystemId string
+	Usage        int64
+	External     bool
+
+	// The options of the volume, and the options it was created with.
+	Options Options
+	Tags    Options
+}
+
+type cachedVolume struct {
+	FileSystemId string
+	Options      Options
+	Expires      time.Time
+}
+
+type cachedTarget struct {
+	Target  *efs.MountTargetDescription
+	Expires time.Time
+}
+
+type cachedStatus struct {
+	Status  *VolumeStatus
+	Expires time.Time
+}
+
+// Helper function to create a cache. Returns nil (which never caches) when
+// the TTL is 0.
+func NewMountCache(ttl time.Duration) *MountCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &MountCache{
+		TTL:      ttl,
+		volumes:  make(map[string]cachedVolume),
+		targets:  make(map[string]cachedTarget),
+		statuses: make(map[string]cachedStatus),
+	}
+}
+
+// Get returns the options and mount target of a volume in an availability
+// zone, if they are cached.
+func (c *MountCache) Get(name, zone string) (Options, *efs.MountTargetDescription, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	v, ok := c.volumes[name]
+	if !ok || now.After(v.Expires) {
+		metricMountCache.Inc("miss")
+		return nil, nil, false
+	}
+	t, ok := c.targets[v.FileSystemId+"|"+zone]
+	if !ok || now.After(t.Expires) {
+		metricMountCache.Inc("miss")
+		return nil, nil, false
+	}
+
+	metricMountCache.Inc("hit")
+	return v.Options, t.Target, true
+}
+
+// Put caches the options and mount target of a volume in an availability
+// zone.
+func (c *MountCache) Put(name, zone string, o Options, m *efs.MountTargetDescription) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.TTL)
+	c.volumes[name] = cachedVolume{
+		FileSystemId: *m.FileSystemId,
+		Options:      o,
+		Expires:      expires,
+	}
+	c.targets[*m.FileSystemId+"|"+zone] = cachedTarget{
+		Target:  m,
+		Expires: expires,
+	}
+}
+
+// Status returns the description of a volume, if it is cached.
+func (c *MountCache) Status(name string) (*VolumeStatus, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.statuses[name]
+	if !ok || time.Now().After(s.Expires) {
+		metricStatusCache.Inc("miss")
+		return nil, false
+	}
+
+	metricStatusCache.Inc("hit")
+	return s.Status, true
+}
+
+// PutStatus caches the description of a volume.
+func (c *MountCache) PutStatus(name string, s *VolumeStatus) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.statuses[name] = cachedStatus{
+		Status:  s,
+		Expires: time.Now().Add(c.TTL),
+	}
+}
+
+// Invalidate removes a volume, and the mount targets of its filesystem, from
+// the cache (eg. because mounting it failed).
+func (c *MountCache) Invalidate(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.statuses, name)
+
+	v, ok := c.volumes[name]
+	if !ok {
+		return
+	}
+	delete(c.volumes, name)
+	for k, t := range c.targets {
+		if *t.Target.FileSystemId == v.FileSystemId {
+			delete(c.targets, k)
+		}
+	}
+}
+
+// Flush empties the cache (eg. because the configuration was reloaded).
+func (c *MountCache) Flush() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.volumes = make(map[string]cachedVolume)
+	c.targets = make(map[string]cachedTarget)
+	c.statuses = make(map[string]cachedStatus)
+}
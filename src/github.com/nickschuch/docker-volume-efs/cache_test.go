@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+func mountTarget(fsid, ip string) *efs.MountTargetDescription {
+	return &efs.MountTargetDescription{FileSystemId: aws.String(fsid), IpAddress: aws.String(ip)}
+}
+
+func TestMountCache(t *testing.T) {
+	o := Options{optMountOptions: "hard"}
+
+	tests := []struct {
+		name  string
+		steps func(c *MountCache)
+		zone  string
+		want  string
+	}{
+		{
+			name:  "empty",
+			steps: func(c *MountCache) {},
+			zone:  "a",
+		},
+		{
+			name:  "cached",
+			steps: func(c *MountCache) { c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1")) },
+			zone:  "a",
+			want:  "10.0.0.1",
+		},
+		{
+			name:  "another zone",
+			steps: func(c *MountCache) { c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1")) },
+			zone:  "b",
+		},
+		{
+			name: "expired",
+			steps: func(c *MountCache) {
+				c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				time.Sleep(2 * c.TTL)
+			},
+			zone: "a",
+		},
+		{
+			name: "invalidated",
+			steps: func(c *MountCache) {
+				c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				c.Invalidate("foo")
+			},
+			zone: "a",
+		},
+		{
+			name: "another volume invalidated",
+			steps: func(c *MountCache) {
+				c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				c.Put("bar", "a", o, mountTarget("fs-2", "10.0.0.2"))
+				c.Invalidate("bar")
+			},
+			zone: "a",
+			want: "10.0.0.1",
+		},
+		{
+			name: "volume sharing an invalidated filesystem",
+			steps: func(c *MountCache) {
+				c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				c.Put("alias", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				c.Invalidate("alias")
+			},
+			zone: "a",
+		},
+		{
+			name: "flushed",
+			steps: func(c *MountCache) {
+				c.Put("foo", "a", o, mountTarget("fs-1", "10.0.0.1"))
+				c.Flush()
+			},
+			zone: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		c := NewMountCache(20 * time.Millisecond)
+		tt.steps(c)
+
+		got, m, ok := c.Get("foo", tt.zone)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: got %s, want nothing", tt.name, *m.IpAddress)
+			}
+			continue
+		}
+		if !ok || *m.IpAddress != tt.want || got.Get(optMountOptions, "") != "hard" {
+			t.Errorf("%s: got %v, %v, want %s", tt.name, got, m, tt.want)
+		}
+	}
+}
+
+func TestMountCacheDisabled(t *testing.T) {
+	c := NewMountCache(0)
+	if c != nil {
+		t.Fatalf("got a cache with a TTL of 0")
+	}
+
+	// A nil cache never caches.
+	c.Put("foo", "a", Options{}, mountTarget("fs-1", "10.0.0.1"))
+	c.PutStatus("foo", &VolumeStatus{FileSystemId: "fs-1"})
+	if _, _, ok := c.Get("foo", "a"); ok {
+		t.Error("got a mount target from a nil cache")
+	}
+	if _, ok := c.Status("foo"); ok {
+		t.Error("got a status from a nil cache")
+	}
+	c.Invalidate("foo")
+	c.Flush()
+}
+
+func TestMountCacheStatus(t *testing.T) {
+	c := NewMountCache(time.Minute)
+	c.PutStatus("foo", &VolumeStatus{FileSystemId: "fs-1"})
+	if s, ok := c.Status("foo"); !ok || s.FileSystemId != "fs-1" {
+		t.Errorf("got %v, want fs-1", s)
+	}
+
+	// Invalidating a volume (eg. when mounting it failed) describes it again.
+	c.Invalidate("foo")
+	if _, ok := c.Status("foo"); ok {
+		t.Error("got a status of an invalidated volume")
+	}
+}
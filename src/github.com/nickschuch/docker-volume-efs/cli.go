@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// Besides running the volume plugin, the binary has commands for operating
+// on volumes (eg. snapshots). These run on an EC2 host like the plugin, and
+// mount the volumes they need privately so the plugin's cleanup task doesn't
+// touch them.
+
+var (
+	cmdServe = kingpin.Command("serve", "Run the volume plugin (the default when no command is given).")
+)
+
+// Helper function to run the plugin when no command is given, so existing
+// invocations (eg. docker-volume-efs --root=/mnt/efs) keep working.
+func CommandArgs(args []string) []string {
+	commands := make(map[string]bool)
+	for _, c := range kingpin.CommandLine.Model().Commands {
+		commands[c.Name] = true
+	}
+
+	for _, a := range args {
+		if commands[a] || a == "help" || a == "--help" {
+			return args
+		}
+	}
+	return append([]string{cmdServe.FullCommand()}, args...)
+}
+
+// Helper function to run a command other than serve.
+func RunCommand(command string) error {
+	d, err := NewDriver(*cliRoot)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case cmdSnapshotCreate.FullCommand():
+		return SnapshotCreate(d)
+	case cmdSnapshotList.FullCommand():
+		return SnapshotList(d)
+	case cmdSnapshotRestore.FullCommand():
+		return SnapshotRestore(d)
+	case cmdSnapshotDelete.FullCommand():
+		return SnapshotDelete(d)
+	case cmdBackupCreate.FullCommand():
+		return BackupCreate(d)
+	case cmdBackupList.FullCommand():
+		return BackupList(d)
+	case cmdBackupRestore.FullCommand():
+		return BackupRestore(d)
+	case cmdBackupPrune.FullCommand():
+		return BackupPrune(d)
+	case cmdBackupCoverage.FullCommand():
+		return BackupCoverageReport(d)
+	case cmdCost.FullCommand():
+		return CostEstimate(d)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// Helper function to mount an existing volume in a private directory for a
+// command. The returned function unmounts it again.
+func (d DriverEFS) MountPrivate(l *log.Entry, n string) (string, func(), error) {
+	return d.mountPrivate(l, n, false)
+}
+
+// Helper function to mount a volume in a private directory for a command,
+// creating its EFS Filesystem if it doesn't exist (eg. to restore a backup
+// into). The returned function unmounts it again.
+func (d DriverEFS) MountPrivateOrCreate(l *log.Entry, n string) (string, func(), error) {
+	return d.mountPrivate(l, n, true)
+}
+
+func (d DriverEFS) mountPrivate(l *log.Entry, n string, create bool) (string, func(), error) {
+	cfg := CurrentConfig()
+	if err := cfg.Policy.Check(n); err != nil {
+		return "", nil, err
+	}
+
+	// A mistyped volume name mustn't provision a new EFS Filesystem.
+	var o Options
+	var m *efs.MountTargetDescription
+	var err error
+	if create {
+		o, m, err = d.MountTarget(l, n)
+	} else {
+		var e *efs.EFS
+		var fs *efs.FileSystemDescription
+		e, fs, o, err = d.Lookup(l, n)
+		if err == nil && fs == nil {
+			err = fmt.Errorf("no such volume: %s", n)
+		}
+		if err == nil {
+			m, err = GetEFS(l, e, cfg.Policy, d.Subnets(o), o, n)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if err := CheckRoute(m, *cliRouteTimeout); err != nil {
+		return "", nil, err
+	}
+	addr, zone, err := MountAddress(l, m, d.region(o), d.zone(o), o)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p, err := ioutil.TempDir("", "docker-volume-efs-")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := Exec("mount", MountArgs(m, addr, zone, p, o)...); err != nil {
+		os.Remove(p)
+		return "", nil, err
+	}
+	l.WithFields(log.Fields{
+		"filesystem_id": *m.FileSystemId,
+		"path":          p,
+	}).Debug("Mounted privately")
+
+	unmount := func() {
+		if err := Exec("umount", p); err != nil {
+			l.WithField("error", err).Warn("Cannot unmount")
+			return
+		}
+		os.Remove(p)
+	}
+	return p, unmount, nil
+}
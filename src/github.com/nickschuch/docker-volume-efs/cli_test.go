@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestMountPrivateMissing(t *testing.T) {
+	d, _ := newFakeDriver(t, nil)
+	l := log.WithField("test", t.Name())
+
+	// A mistyped volume name fails instead of creating a filesystem.
+	_, _, err := d.MountPrivate(l, "typo")
+	if err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("got %v, want no such volume", err)
+	}
+}
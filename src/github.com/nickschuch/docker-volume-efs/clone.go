@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+const (
+	// Create a volume with a copy of another volume (from=volume) or of one of
+	// its snapshots (from=volume@snapshot).
+	optFrom = "from"
+
+	// Whether Create waits for the copy (wait) or returns while it continues
+	// in the background (background).
+	optClone = "clone"
+
+	// Recorded by the plugin (not given with -o) on the EFS Filesystem of a
+	// volume until it has been cloned, so no host mounts it half copied, even
+	// if the plugin is restarted during the copy.
+	optCloning = "cloning"
+	tagCloning = tagPrefix + optCloning
+
+	cloneWait       = "wait"
+	cloneBackground = "background"
+
+	cloneWorkers = 16
+)
+
+var (
+	// Clones in progress (or finished) since the plugin started, by volume.
+	clones   = make(map[string]*Clone)
+	clonesMu sync.Mutex
+)
+
+// Clone is a copy of a volume into a new volume.
+type Clone struct {
+	Source   string
+	Snapshot string
+	Started  time.Time
+
+	copier   *Copier
+	mu       sync.Mutex
+	finished time.Time
+	err      error
+}
+
+// Helper function to parse the from option into a volume and snapshot.
+func CloneSource(o Options) (string, string, error) {
+	v := o.Get(optFrom, "")
+	if v == "" {
+		return "", "", nil
+	}
+
+	parts := strings.SplitN(v, "@", 2)
+	for _, p := range parts {
+		if err := ValidateName(p); err != nil {
+			return "", "", fmt.Errorf("invalid %s: %s", optFrom, err)
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0], "", nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// SourceOptions gets the options of the volume a new volume is cloned from.
+func (d DriverEFS) SourceOptions(l *log.Entry, source string) (Options, error) {
+	_, fs, o, err := d.Lookup(l, source)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return nil, fmt.Errorf("cannot clone volume %s: it does not exist", source)
+	}
+	return o, nil
+}
+
+// Helper function to check that a volume can be cloned. The data of a volume
+// with an owner can only be copied into a volume with the same owner, so
+// another team can't clone it into a volume of their own.
+func AuthorizeClone(label, source string, so, o Options) error {
+	owner := so.Get(optOwner, "")
+	if label == "" || owner == "" {
+		return nil
+	}
+	if o.Get(optOwner, "") != owner {
+		return fmt.Errorf("cannot clone volume %s owned by %s into a volume with another owner", source, owner)
+	}
+	return nil
+}
+
+// Helper function to determine if Create should wait for a clone.
+func CloneInBackground(o Options) (bool, error) {
+	switch v := o.Get(optClone, cloneWait); v {
+	case cloneWait:
+		return false, nil
+	case cloneBackground:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s: %s (%s, %s)", optClone, v, cloneWait, cloneBackground)
+	}
+}
+
+// Helper function to get the tag which marks a new volume as being cloned,
+// from the options it is created with.
+func CloningTags(o Options) []*efs.Tag {
+	v := o.Get(optFrom, "")
+	if v == "" {
+		return nil
+	}
+	return []*efs.Tag{{Key: aws.String(tagCloning), Value: aws.String(v)}}
+}
+
+// Helper function to get the clone of a volume, if there is one.
+func GetClone(n string) *Clone {
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+	return clones[n]
+}
+
+// Running returns true if the clone hasn't finished.
+func (c *Clone) Running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.finished.IsZero()
+}
+
+// Status describes the clone for Get and List calls.
+func (c *Clone) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.copier.Current()
+	s := map[string]interface{}{
+		"cloneSource": c.Source,
+		"cloneFiles":  fmt.Sprintf("%d/%d", p.Files, p.TotalFiles),
+		"cloneBytes":  fmt.Sprintf("%d/%d", p.Bytes, p.TotalBytes),
+	}
+	if c.Snapshot != "" {
+		s["cloneSource"] = c.Source + "@" + c.Snapshot
+	}
+
+	switch {
+	case c.finished.IsZero():
+		s["clone"] = "copying"
+	case c.err != nil:
+		s["clone"] = "failed"
+		s["cloneError"] = c.err.Error()
+	default:
+		s["clone"] = "done"
+		s["cloneDuration"] = c.finished.Sub(c.Started).String()
+	}
+	return s
+}
+
+// Helper function to copy a volume (or a snapshot of it) into a new volume.
+// The copy is skipped if the new volume already has data (eg. Create was
+// called again with the same options). The cloning tag is removed from its
+// EFS Filesystem once the copy has finished, and kept if it failed.
+func (d DriverEFS) Clone(l *log.Entry, e *efs.EFS, id, n string, o Options) error {
+	source, snapshot, err := CloneSource(o)
+	if err != nil || source == "" {
+		return err
+	}
+	background, err := CloneInBackground(o)
+	if err != nil {
+		return err
+	}
+
+	clonesMu.Lock()
+	if c, ok := clones[n]; ok && c.Running() {
+		clonesMu.Unlock()
+		return fmt.Errorf("volume %s is already being cloned", n)
+	}
+	c := &Clone{
+		Source:   source,
+		Snapshot: snapshot,
+		Started:  time.Now(),
+		copier: &Copier{
+			Workers:  cloneWorkers,
+			Exclude:  []string{snapshotDir},
+			Progress: copyProgress(l),
+			Interval: 5 * time.Second,
+		},
+	}
+	clones[n] = c
+	clonesMu.Unlock()
+
+	l = l.WithField("from", o.Get(optFrom, ""))
+	l.Info("Cloning")
+
+	run := func() error {
+		err := d.clone(l, c, n)
+		if err == nil {
+			err = UntagFilesystem(e, id, tagCloning)
+			d.Cache.Invalidate(n)
+		}
+		c.mu.Lock()
+		c.finished = time.Now()
+		c.err = err
+		c.mu.Unlock()
+
+		if err != nil {
+			l.WithField("error", err).Error("Clone failed")
+			return err
+		}
+		l.WithField("duration", c.finished.Sub(c.Started).String()).Info("Cloned")
+		return nil
+	}
+
+	if background {
+		go run()
+		return nil
+	}
+	return run()
+}
+
+func (d DriverEFS) clone(l *log.Entry, c *Clone, n string) error {
+	src, unmountSrc, err := d.MountPrivate(l, c.Source)
+	if err != nil {
+		return err
+	}
+	defer unmountSrc()
+
+	if c.Snapshot != "" {
+		src = filepath.Join(src, snapshotDir, c.Snapshot)
+		if !Exists(src + snapshotSuffix) {
+			return fmt.Errorf("snapshot %s of %s does not exist", c.Snapshot, c.Source)
+		}
+	}
+
+	dst, unmountDst, err := d.MountPrivate(l, n)
+	if err != nil {
+		return err
+	}
+	defer unmountDst()
+
+	if !EmptyDir(dst, snapshotDir) {
+		l.Warn("Volume already has data, not cloning")
+		return nil
+	}
+
+	return c.copier.Copy(src, dst)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+func TestCloneSource(t *testing.T) {
+	tests := []struct {
+		from     string
+		volume   string
+		snapshot string
+		invalid  bool
+	}{
+		{"", "", "", false},
+		{"foo", "foo", "", false},
+		{"foo@daily", "foo", "daily", false},
+		{"foo@", "", "", true},
+		{"../foo", "", "", true},
+	}
+	for _, tt := range tests {
+		v, s, err := CloneSource(Options{optFrom: tt.from})
+		if tt.invalid != (err != nil) || v != tt.volume || s != tt.snapshot {
+			t.Errorf("CloneSource(%q) = %q, %q, %v", tt.from, v, s, err)
+		}
+	}
+}
+
+func TestAuthorizeClone(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   string
+		source  Options
+		clone   Options
+		allowed bool
+	}{
+		{"same owner", testOwnerLabel, Options{optOwner: "a"}, Options{optOwner: "a"}, true},
+		{"another owner", testOwnerLabel, Options{optOwner: "a"}, Options{optOwner: "b"}, false},
+		{"without an owner", testOwnerLabel, Options{optOwner: "a"}, Options{}, false},
+		{"source without an owner", testOwnerLabel, Options{}, Options{optOwner: "b"}, true},
+		{"ownership disabled", "", Options{optOwner: "a"}, Options{optOwner: "b"}, true},
+	}
+	for _, tt := range tests {
+		err := AuthorizeClone(tt.label, "foo", tt.source, tt.clone)
+		if tt.allowed != (err == nil) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestMountableTargetCloning(t *testing.T) {
+	p := CurrentConfig().Policy
+	filesystems := []*taggedFileSystem{{
+		FileSystemId:  aws.String("fs-11111111"),
+		CreationToken: aws.String(p.Token("foo")),
+		Tags:          append(CloningTags(Options{optFrom: "bar"}), p.Tags()...),
+	}}
+	d, _ := newFakeDriver(t, filesystems)
+	l := log.WithField("test", t.Name())
+
+	_, _, err := d.MountableTarget(l, "foo", false)
+	if want := "volume foo has not finished cloning from bar"; err == nil || err.Error() != want {
+		t.Errorf("cloning: got %v, want %s", err, want)
+	}
+
+	// Options cached before the clone finished (eg. on another host) are
+	// checked again.
+	d.Cache.Put("foo", d.Zone, Options{optCloning: "bar"}, &efs.MountTargetDescription{
+		FileSystemId: aws.String("fs-11111111"),
+		IpAddress:    aws.String("10.0.0.1"),
+	})
+	filesystems[0].Tags = p.Tags()
+	o, m, err := d.MountableTarget(l, "foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Get(optCloning, "") != "" || aws.StringValue(m.IpAddress) != "10.0.0.1" {
+		t.Errorf("cloned: got %v, %v", o, m)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/vaughan0/go-ini"
+)
+
+const (
+	configHelp = `Docker volume plugin for AWS EFS.
+
+Settings are read from, in order of precedence (highest first):
+
+  1. Command line flags.
+  2. Environment variables (see each flag).
+  3. The [global] section of the configuration file (--config).
+  4. Built-in defaults.
+
+Volumes are configured from, in order of precedence (highest first):
+
+  1. Options given when the volume is created (docker volume create -o key=value).
+  2. The [volume "name"] section of the configuration file.
+  3. The [profile "name"] section selected with "profile = name".
+  4. The global settings above.
+
+Existing EFS Filesystems are bound to volume names in the [aliases] section
+(name = fs-12345678) or with "fsid = fs-12345678".
+
+Prices for cost estimates are set in the [prices] section, and for a region
+in the [prices "region"] section.`
+
+	// Keys shared by the [global], [volume "name"] and [profile "name"]
+	// sections and volume options.
+	optRegion          = "region"
+	optSubnet          = "subnet"
+	optSubnets         = "subnets"
+	optSecurityGroups  = "security_groups"
+	optMountOptions    = "mount_options"
+	optCleanupInterval = "cleanup_interval"
+	optProfile         = "profile"
+
+	defaultCleanupInterval = 15 * time.Second
+)
+
+var (
+	cliConfig          = kingpin.Flag("config", "Path to the configuration file.").Default("/etc/docker-volume-efs.conf").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_CONFIG").String()
+	cliRegion          = kingpin.Flag("region", "AWS region of the EFS Filesystems. Discovered from the EC2 metadata when not set.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_REGION").String()
+	cliSubnets         = kingpin.Flag("subnets", "Comma separated subnets to create new EFS Mount points in. Defaults to the subnet of this host.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SUBNETS").String()
+	cliMountOptions    = kingpin.Flag("mount-options", "Default NFS mount options (eg. nfsvers=4.1,hard,timeo=600).").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_MOUNT_OPTIONS").String()
+	cliCleanupInterval = kingpin.Flag("cleanup-interval", "How often to unmount volumes which are not used by a container (eg. 30s). Defaults to cleanup_interval in the configuration file, or 15s.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_CLEANUP_INTERVAL").String()
+
+	// The options a volume can be created with (-o key=value).
+	volumeOptions = []string{
+		optRegion, optSubnet, optSubnets, optSecurityGroups, optMountOptions, optProfile,
+		optPerformanceMode, optThroughputMode, optProvisionedThroughput, optEncrypted, optKmsKeyId, optTransitionToIA,
+		optTLS, optIAM, optDNS, optFsid, optOwner, optBackup, optFrom, optClone, optQuota, optQuotaAction,
+	}
+
+	// Matches [volume "name"], [profile "name"] and [prices "region"] section
+	// names.
+	configSectionRegex = regexp.MustCompile(`^(volume|profile|prices)\s+"([^"]+)"$`)
+
+	config   = NewConfig()
+	configMu sync.RWMutex
+)
+
+// Options is a set of key/value settings for the plugin or a volume.
+type Options map[string]string
+
+// Get returns the value of a key, or the fallback when it is not set.
+func (o Options) Get(key, fallback string) string {
+	if v, ok := o[key]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Bool returns a boolean value, which is false when it is not set.
+func (o Options) Bool(key string) (bool, error) {
+	v := o.Get(key, "")
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", key, v)
+	}
+	return b, nil
+}
+
+// List returns a comma separated value as a list.
+func (o Options) List(key string) []string {
+	var list []string
+	for _, v := range strings.Split(o[key], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Merge returns a copy of these options overridden by each of the given
+// option sets in turn.
+func (o Options) Merge(others ...Options) Options {
+	merged := make(Options)
+	for k, v := range o {
+		merged[k] = v
+	}
+	for _, other := range others {
+		for k, v := range other {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Helper function to check that options given when creating a volume are
+// all known, so a mistyped one isn't ignored (and recorded as a tag).
+func UnknownOptions(o Options) error {
+	var unknown []string
+	for k := range o {
+		if !Contains(volumeOptions, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown volume option: %s", strings.Join(unknown, ", "))
+}
+
+// Config is the effective plugin configuration.
+type Config struct {
+	Global   Options
+	Volumes  map[string]Options
+	Profiles map[string]Options
+	Prices   map[string]Options
+	Policy   *Policy
+}
+
+// Helper function to create an empty configuration.
+func NewConfig() *Config {
+	return &Config{
+		Global:   make(Options),
+		Volumes:  make(map[string]Options),
+		Profiles: make(map[string]Options),
+		Prices:   make(map[string]Options),
+		Policy:   &Policy{},
+	}
+}
+
+// Helper function to load the configuration file and apply the environment
+// and CLI flags on top of it. A missing configuration file is not an error.
+func LoadConfig(path string) (*Config, error) {
+	c := NewConfig()
+
+	f := make(ini.File)
+	if err := f.LoadFile(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot load %s: %s", path, err)
+	}
+
+	var aliases ini.Section
+	for name, section := range f {
+		switch {
+		case name == "" || name == "global":
+			c.Global = c.Global.Merge(Options(section))
+		case name == "aliases":
+			aliases = section
+		case name == "prices":
+			c.Prices[""] = Options(section)
+		case configSectionRegex.MatchString(name):
+			m := configSectionRegex.FindStringSubmatch(name)
+			switch m[1] {
+			case "volume":
+				c.Volumes[m[2]] = Options(section)
+			case "profile":
+				c.Profiles[m[2]] = Options(section)
+			case "prices":
+				c.Prices[m[2]] = Options(section)
+			}
+		default:
+			return nil, fmt.Errorf("cannot load %s: unknown section [%s]", path, name)
+		}
+	}
+
+	for name, id := range aliases {
+		c.Volumes[name] = c.Volumes[name].Merge(Options{optFsid: id})
+	}
+
+	// Flags and environment variables take precedence over the file.
+	flags := map[string]string{
+		optRegion:          *cliRegion,
+		optSubnets:         *cliSubnets,
+		optSecurityGroups:  *cliSecurity,
+		optMountOptions:    *cliMountOptions,
+		optRoleArn:         *cliRoleArn,
+		optExternalId:      *cliExternalId,
+		optNamespace:       *cliNamespace,
+		optAllowNames:      *cliAllowNames,
+		optDenyNames:       *cliDenyNames,
+		optAdoptFsids:      *cliAdoptFsids,
+		optOwnerLabel:      *cliOwnerLabel,
+		optCleanupInterval: *cliCleanupInterval,
+	}
+	if *cliMaxFilesystems > 0 {
+		flags[optMaxFilesystems] = strconv.Itoa(*cliMaxFilesystems)
+	}
+	for k, v := range flags {
+		if v != "" {
+			c.Global[k] = v
+		}
+	}
+
+	if _, err := c.CleanupInterval(); err != nil {
+		return nil, err
+	}
+	policy, err := NewPolicy(c.Global)
+	if err != nil {
+		return nil, err
+	}
+	c.Policy = policy
+	if _, ok := c.Global[optFsid]; ok {
+		return nil, fmt.Errorf("%s can only be set for a volume", optFsid)
+	}
+	for name, p := range c.Profiles {
+		if _, ok := p[optFsid]; ok {
+			return nil, fmt.Errorf("profile %s: %s can only be set for a volume", name, optFsid)
+		}
+	}
+	for name, v := range c.Volumes {
+		if p, ok := v[optProfile]; ok {
+			if _, ok := c.Profiles[p]; !ok {
+				return nil, fmt.Errorf("volume %s uses unknown profile %s", name, p)
+			}
+		}
+		if id, ok := v[optFsid]; ok {
+			if err := ValidateFsid(id); err != nil {
+				return nil, fmt.Errorf("volume %s: %s", name, err)
+			}
+		}
+	}
+	for region, p := range c.Prices {
+		if err := ValidatePrices(p); err != nil {
+			return nil, fmt.Errorf("prices %s: %s", region, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Locations returns each distinct combination of the roles and regions used
+// by the global settings, profiles and volumes, starting with the global
+// ones. An empty role is the credentials of this host and an empty region is
+// the region of this host.
+func (c *Config) Locations() []Options {
+	var roles, regions []Options
+	seenRoles := make(map[string]bool)
+	seenRegions := make(map[string]bool)
+
+	for _, o := range append([]Options{c.Global}, c.sections()...) {
+		role := Options{
+			optRoleArn:    o.Get(optRoleArn, c.Global.Get(optRoleArn, "")),
+			optExternalId: o.Get(optExternalId, c.Global.Get(optExternalId, "")),
+		}
+		if key := role[optRoleArn] + "|" + role[optExternalId]; !seenRoles[key] {
+			seenRoles[key] = true
+			roles = append(roles, role)
+		}
+
+		region := o.Get(optRegion, c.Global.Get(optRegion, ""))
+		if !seenRegions[region] {
+			seenRegions[region] = true
+			regions = append(regions, Options{optRegion: region})
+		}
+	}
+
+	var locations []Options
+	for _, region := range regions {
+		for _, role := range roles {
+			locations = append(locations, role.Merge(region))
+		}
+	}
+	return locations
+}
+
+// Regions returns the distinct regions used by the configuration. An empty
+// region is the region of this host.
+func (c *Config) Regions() []string {
+	var regions []string
+	for _, l := range c.Locations() {
+		if r := l[optRegion]; !Contains(regions, r) {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// Helper function to get the profile and volume sections in a stable order.
+func (c *Config) sections() []Options {
+	var sections []Options
+	for _, m := range []map[string]Options{c.Profiles, c.Volumes} {
+		names := make([]string, 0, len(m))
+		for n := range m {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			sections = append(sections, m[n])
+		}
+	}
+	return sections
+}
+
+// CleanupInterval returns how often the cleanup task runs.
+func (c *Config) CleanupInterval() (time.Duration, error) {
+	v, ok := c.Global[optCleanupInterval]
+	if !ok || v == "" {
+		return defaultCleanupInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", optCleanupInterval, err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("invalid %s: must be at least 1s", optCleanupInterval)
+	}
+	return d, nil
+}
+
+// VolumeOptions resolves the options for a volume by merging the global
+// settings, the selected profile, the volume section and explicit options.
+func (c *Config) VolumeOptions(name string, explicit Options) (Options, error) {
+	volume := c.Volumes[name]
+
+	profile := explicit.Get(optProfile, volume.Get(optProfile, ""))
+	if profile == "" {
+		return c.Global.Merge(volume, explicit), nil
+	}
+
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", profile)
+	}
+	return c.Global.Merge(p, volume, explicit), nil
+}
+
+// Helper function to get the current configuration.
+func CurrentConfig() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// Helper function to (re)load the configuration and make it current.
+func ReloadConfig() error {
+	c, err := LoadConfig(*cliConfig)
+	if err != nil {
+		return err
+	}
+
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+	return nil
+}
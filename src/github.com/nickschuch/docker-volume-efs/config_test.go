@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	base := Options{"a": "base", "b": "base"}
+	got := base.Merge(Options{"b": "first", "c": "first"}, Options{"c": "second"})
+	want := Options{"a": "base", "b": "first", "c": "second"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Merging doesn't change the options merged.
+	if base["b"] != "base" {
+		t.Errorf("merging changed the base options: %v", base)
+	}
+}
+
+func TestVolumeOptions(t *testing.T) {
+	c := NewConfig()
+	c.Global = Options{optRegion: "global", optSubnets: "global", optMountOptions: "global", optQuota: "global"}
+	c.Profiles["fast"] = Options{optSubnets: "profile", optMountOptions: "profile", optQuota: "profile"}
+	c.Profiles["slow"] = Options{optQuota: "slow"}
+	c.Volumes["foo"] = Options{optProfile: "fast", optMountOptions: "volume", optQuota: "volume"}
+	c.Volumes["bar"] = Options{optQuota: "volume"}
+
+	tests := []struct {
+		name     string
+		volume   string
+		explicit Options
+		want     Options
+		err      bool
+	}{
+		{
+			name:   "global",
+			volume: "baz",
+			want:   Options{optRegion: "global", optSubnets: "global", optMountOptions: "global", optQuota: "global"},
+		},
+		{
+			name:   "volume section",
+			volume: "bar",
+			want:   Options{optRegion: "global", optSubnets: "global", optMountOptions: "global", optQuota: "volume"},
+		},
+		{
+			name:   "profile of the volume section",
+			volume: "foo",
+			want:   Options{optRegion: "global", optSubnets: "profile", optMountOptions: "volume", optQuota: "volume", optProfile: "fast"},
+		},
+		{
+			name:     "explicit options",
+			volume:   "foo",
+			explicit: Options{optQuota: "explicit"},
+			want:     Options{optRegion: "global", optSubnets: "profile", optMountOptions: "volume", optQuota: "explicit", optProfile: "fast"},
+		},
+		{
+			name:     "explicit profile",
+			volume:   "baz",
+			explicit: Options{optProfile: "fast"},
+			want:     Options{optRegion: "global", optSubnets: "profile", optMountOptions: "profile", optQuota: "profile", optProfile: "fast"},
+		},
+		{
+			name:     "explicit profile instead of the volume section's",
+			volume:   "foo",
+			explicit: Options{optProfile: "slow"},
+			want:     Options{optRegion: "global", optSubnets: "global", optMountOptions: "volume", optQuota: "volume", optProfile: "slow"},
+		},
+		{
+			name:     "unknown profile",
+			volume:   "baz",
+			explicit: Options{optProfile: "missing"},
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := c.VolumeOptions(tt.volume, tt.explicit)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestUnknownOptions(t *testing.T) {
+	for _, tc := range []struct {
+		options Options
+		want    string
+	}{
+		{Options{}, ""},
+		{Options{optProfile: "fast", optThroughputMode: "elastic", optOwner: "team-a", optFrom: "foo", optQuota: "1GiB"}, ""},
+		{Options{"trhoughput_mode": "elastic"}, "unknown volume option: trhoughput_mode"},
+		{Options{optProfile: "fast", "zzz": "1", "aaa": "2"}, "unknown volume option: aaa, zzz"},
+	} {
+		var got string
+		if err := UnknownOptions(tc.options); err != nil {
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.options, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "efs.ini")
+	err := ioutil.WriteFile(p, []byte(`
+[global]
+region = us-west-2
+mount_options = nfsvers=4.1
+cleanup_interval = 1m
+adopt_fsids = fs-11111111, fs-22222222
+
+[profile "fast"]
+throughput_mode = elastic
+
+[volume "foo"]
+profile = fast
+
+[aliases]
+foo = fs-12345678
+shared = fs-87654321
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags take precedence over the file.
+	region := *cliRegion
+	*cliRegion = "eu-west-1"
+	defer func() { *cliRegion = region }()
+
+	c, err := LoadConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Global.Get(optRegion, ""); got != "eu-west-1" {
+		t.Errorf("region: got %s, want the flag eu-west-1", got)
+	}
+	if got := c.Global.Get(optMountOptions, ""); got != "nfsvers=4.1" {
+		t.Errorf("mount_options: got %s, want nfsvers=4.1", got)
+	}
+	if got := c.Policy.Adoptable; !reflect.DeepEqual(got, []string{"fs-11111111", "fs-22222222"}) {
+		t.Errorf("%s: got %v", optAdoptFsids, got)
+	}
+	if got, _ := c.CleanupInterval(); got != time.Minute {
+		t.Errorf("%s: got %s, want the file 1m", optCleanupInterval, got)
+	}
+	if got := c.Profiles["fast"].Get("throughput_mode", ""); got != "elastic" {
+		t.Errorf("profile: got %s, want elastic", got)
+	}
+	want := map[string]Options{
+		"foo":    {optProfile: "fast", optFsid: "fs-12345678"},
+		"shared": {optFsid: "fs-87654321"},
+	}
+	if !reflect.DeepEqual(c.Volumes, want) {
+		t.Errorf("volumes: got %v, want %v", c.Volumes, want)
+	}
+
+	// A missing file is an empty configuration.
+	c, err = LoadConfig(filepath.Join(t.TempDir(), "missing.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.CleanupInterval(); got != defaultCleanupInterval {
+		t.Errorf("%s: got %s, want the default %s", optCleanupInterval, got, defaultCleanupInterval)
+	}
+
+	interval := *cliCleanupInterval
+	defer func() { *cliCleanupInterval = interval }()
+	*cliCleanupInterval = "30s"
+	if c, err := LoadConfig(p); err != nil {
+		t.Error(err)
+	} else if got, _ := c.CleanupInterval(); got != 30*time.Second {
+		t.Errorf("%s: got %s, want the flag 30s", optCleanupInterval, got)
+	}
+	*cliCleanupInterval = "soon"
+	if _, err := LoadConfig(p); err == nil {
+		t.Errorf("%s: got no error for soon", optCleanupInterval)
+	}
+	*cliCleanupInterval = interval
+	for _, bad := range []string{"[unknown]\n", "[global]\nadopt_fsids = 12345678\n"} {
+		if err := ioutil.WriteFile(p, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(p); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	copyBufferSize = 1 << 20
+)
+
+// Copier copies a directory tree with several workers, preserving ownership,
+// modes, timestamps, extended attributes, hard links and sparse files.
+type Copier struct {
+	// How many files are copied at once.
+	Workers int
+
+	// Paths (relative to the source) which are not copied.
+	Exclude []string
+
+	// Called every Interval while copying, and once when done.
+	Progress func(CopyProgress)
+	Interval time.Duration
+
+	progress CopyProgress
+}
+
+// CopyProgress reports how much of a copy is done.
+type CopyProgress struct {
+	Files      int64
+	TotalFiles int64
+	Bytes      int64
+	TotalBytes int64
+}
+
+type copyJob struct {
+	Rel  string
+	Info os.FileInfo
+}
+
+type copyLink struct {
+	Rel    string
+	Target string
+}
+
+// Copy copies the contents of src into dst, which is created if needed.
+func (c *Copier) Copy(src, dst string) error {
+	var dirs, files []copyJob
+	var links []copyLink
+	inodes := make(map[uint64]string)
+
+	// Walk the tree first, creating directories, symlinks and special files,
+	// so the total is known for progress reports.
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if Contains(c.Exclude, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		st := info.Sys().(*syscall.Stat_t)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			dirs = append(dirs, copyJob{rel, info})
+			return os.MkdirAll(target, 0700)
+
+		case mode.IsRegular():
+			if st.Nlink > 1 {
+				if first, ok := inodes[st.Ino]; ok {
+					links = append(links, copyLink{rel, first})
+					return nil
+				}
+				inodes[st.Ino] = rel
+			}
+			files = append(files, copyJob{rel, info})
+			c.progress.TotalFiles++
+			c.progress.TotalBytes += info.Size()
+			return nil
+
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return os.Lchown(target, int(st.Uid), int(st.Gid))
+
+		case mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe) != 0:
+			if err := syscall.Mknod(target, st.Mode, int(st.Rdev)); err != nil {
+				return err
+			}
+			return CopyMetadata(p, target, info)
+		}
+
+		// Sockets can't be copied.
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Copy the files.
+	done := make(chan struct{})
+	if c.Progress != nil && c.Interval > 0 {
+		go func() {
+			t := time.NewTicker(c.Interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					c.Progress(c.Current())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	err = c.copyFiles(src, dst, files)
+	close(done)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if err := os.Link(filepath.Join(dst, l.Target), filepath.Join(dst, l.Rel)); err != nil {
+			return err
+		}
+	}
+
+	// Directories are finished last (deepest first), as copying into them
+	// would change their timestamps and they might not be writable.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := CopyMetadata(filepath.Join(src, dirs[i].Rel), filepath.Join(dst, dirs[i].Rel), dirs[i].Info); err != nil {
+			return err
+		}
+	}
+
+	if c.Progress != nil {
+		c.Progress(c.Current())
+	}
+	return nil
+}
+
+// Current returns the progress of the copy.
+func (c *Copier) Current() CopyProgress {
+	return CopyProgress{
+		Files:      atomic.LoadInt64(&c.progress.Files),
+		TotalFiles: c.progress.TotalFiles,
+		Bytes:      atomic.LoadInt64(&c.progress.Bytes),
+		TotalBytes: c.progress.TotalBytes,
+	}
+}
+
+// Helper function to copy files with the workers. Stops at the first error.
+func (c *Copier) copyFiles(src, dst string, files []copyJob) error {
+	return parallel(c.Workers, len(files), func(i int) error {
+		j := files[i]
+		n, err := CopyFile(filepath.Join(src, j.Rel), filepath.Join(dst, j.Rel), j.Info)
+		atomic.AddInt64(&c.progress.Bytes, n)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.progress.Files, 1)
+		return nil
+	})
+}
+
+// Helper function to run a function for n items with several workers. Stops
+// at the first error.
+func parallel(workers, n int, fn func(int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n; i++ {
+		select {
+		case err = <-errs:
+		case jobs <- i:
+			continue
+		}
+		break
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Helper function to copy a regular file. Blocks of zeros are skipped rather
+// than written, so sparse files stay sparse. Returns the bytes copied.
+func CopyFile(src, dst string, info os.FileInfo) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	var copied int64
+	buf := make([]byte, copyBufferSize)
+	zeros := make([]byte, copyBufferSize)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				return copied, err
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+
+	// Extend the file if it ends with a hole.
+	if err := out.Truncate(info.Size()); err != nil {
+		return copied, err
+	}
+	if err := out.Close(); err != nil {
+		return copied, err
+	}
+
+	return copied, CopyMetadata(src, dst, info)
+}
+
+// Helper function to copy the ownership, mode, extended attributes and
+// timestamps of a file or directory.
+func CopyMetadata(src, dst string, info os.FileInfo) error {
+	st := info.Sys().(*syscall.Stat_t)
+
+	// Changing the owner clears the setuid and setgid bits, so it goes first.
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode()); err != nil {
+		return err
+	}
+	if err := CopyXattrs(src, dst); err != nil {
+		return err
+	}
+
+	atime := time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	return os.Chtimes(dst, atime, info.ModTime())
+}
+
+// Helper function to copy extended attributes. Filesystems without them
+// (eg. NFSv4.1, which EFS uses) are skipped.
+func CopyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if unsupported(err) || size == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(src, list)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(src, name, value); err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(dst, name, value[:n], 0); err != nil && !unsupported(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func unsupported(err error) bool {
+	return err == syscall.ENOTSUP || err == syscall.EOPNOTSUPP
+}
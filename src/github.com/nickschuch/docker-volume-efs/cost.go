@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Monthly cost estimates are the size of each storage class and the
+// provisioned throughput (above what the size of the filesystem includes)
+// times their prices. They leave out what depends on usage, eg. Infrequent
+// Access requests, elastic throughput and data transfer.
+
+const (
+	priceStandard    = "standard_gb_month"
+	priceIA          = "ia_gb_month"
+	priceProvisioned = "provisioned_mibps_month"
+
+	// Provisioned throughput up to 50 KiB/s per GiB of Standard storage is
+	// included with the storage.
+	includedMibpsPerGiB = 50.0 / 1024
+
+	gib = 1 << 30
+)
+
+var (
+	// Prices in us-east-1 (USD), used unless the configuration has others.
+	defaultPrices = map[string]float64{
+		priceStandard:    0.30,
+		priceIA:          0.025,
+		priceProvisioned: 6.00,
+	}
+
+	cmdCost       = kingpin.Command("cost", "Estimate the monthly cost of each volume.")
+	cliCostFormat = cmdCost.Flag("format", "Output format (table, json or csv).").Default("table").Enum("table", "json", "csv")
+	cliCostTags   = cmdCost.Flag("tag", "Also total the estimates by the values of this EFS Filesystem tag (repeatable).").Strings()
+)
+
+// VolumeCost is the estimated monthly cost of a volume.
+type VolumeCost struct {
+	Volume           string            `json:"volume"`
+	FilesystemId     string            `json:"filesystemId"`
+	Region           string            `json:"region"`
+	StandardBytes    int64             `json:"standardBytes"`
+	IABytes          int64             `json:"iaBytes"`
+	ThroughputMode   string            `json:"throughputMode"`
+	ProvisionedMibps float64           `json:"provisionedMibps,omitempty"`
+	Storage          float64           `json:"storage"`
+	Throughput       float64           `json:"throughput"`
+	Total            float64           `json:"total"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+// CostReport is the estimated monthly cost of every volume, in total and by
+// the values of tags.
+type CostReport struct {
+	Volumes []*VolumeCost                 `json:"volumes"`
+	Tags    map[string]map[string]float64 `json:"tags,omitempty"`
+	Total   float64                       `json:"total"`
+}
+
+// Helper function to validate a prices section of the configuration.
+func ValidatePrices(o Options) error {
+	for k, v := range o {
+		if _, ok := defaultPrices[k]; !ok {
+			return fmt.Errorf("unknown price %s", k)
+		}
+		if p, err := strconv.ParseFloat(v, 64); err != nil || p < 0 {
+			return fmt.Errorf("invalid %s: %s", k, v)
+		}
+	}
+	return nil
+}
+
+// Price returns a price in a region, from the [prices "region"] section,
+// then the [prices] section, then the defaults.
+func (c *Config) Price(region, key string) float64 {
+	for _, section := range []string{region, ""} {
+		if v, ok := c.Prices[section][key]; ok {
+			if p, err := strconv.ParseFloat(v, 64); err == nil {
+				return p
+			}
+		}
+	}
+	return defaultPrices[key]
+}
+
+// Helper function to estimate the monthly cost of an EFS Filesystem.
+func EstimateCost(c *Config, region string, fs *fileSystemDescription) *VolumeCost {
+	v := &VolumeCost{
+		FilesystemId:   aws.StringValue(fs.FileSystemId),
+		Region:         region,
+		ThroughputMode: aws.StringValue(fs.ThroughputMode),
+	}
+	if v.ThroughputMode == "" {
+		v.ThroughputMode = "bursting"
+	}
+
+	// Filesystems which never used Infrequent Access only report the total.
+	if s := fs.SizeInBytes; s != nil {
+		v.StandardBytes = aws.Int64Value(s.Value)
+		if s.ValueInStandard != nil {
+			v.StandardBytes = aws.Int64Value(s.ValueInStandard)
+		}
+		v.IABytes = aws.Int64Value(s.ValueInIA)
+	}
+
+	v.Storage = float64(v.StandardBytes)/gib*c.Price(region, priceStandard) +
+		float64(v.IABytes)/gib*c.Price(region, priceIA)
+
+	if v.ThroughputMode == "provisioned" {
+		if fs.ProvisionedThroughputInMibps != nil {
+			v.ProvisionedMibps = *fs.ProvisionedThroughputInMibps
+		}
+		if billed := v.ProvisionedMibps - float64(v.StandardBytes)/gib*includedMibpsPerGiB; billed > 0 {
+			v.Throughput = billed * c.Price(region, priceProvisioned)
+		}
+	}
+
+	v.Total = v.Storage + v.Throughput
+	return v
+}
+
+// CostEstimate prints the estimated monthly cost of every volume.
+func CostEstimate(d DriverEFS) error {
+	l := log.WithField("command", cmdCost.FullCommand())
+	cfg := CurrentConfig()
+
+	var names []string
+	for n := range d.KnownVolumes(l) {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	report := &CostReport{
+		Tags: make(map[string]map[string]float64),
+	}
+	for _, t := range *cliCostTags {
+		report.Tags[t] = make(map[string]float64)
+	}
+
+	for _, n := range names {
+		e, fs, o, err := d.Lookup(l, n)
+		if err != nil || fs == nil {
+			l.WithFields(log.Fields{
+				"volume": n,
+				"error":  err,
+			}).Warn("Cannot find EFS Filesystem")
+			continue
+		}
+
+		desc, err := describeFileSystem(e, fs.FileSystemId)
+		if err != nil {
+			return err
+		}
+		v := EstimateCost(cfg, d.region(o), desc)
+		v.Volume = n
+
+		if len(*cliCostTags) > 0 {
+			tags, err := DescribeTags(e, *fs.FileSystemId)
+			if err != nil {
+				return err
+			}
+			v.Tags = make(map[string]string)
+			for _, t := range tags {
+				if Contains(*cliCostTags, aws.StringValue(t.Key)) {
+					v.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+				}
+			}
+			for _, t := range *cliCostTags {
+				report.Tags[t][v.Tags[t]] += v.Total
+			}
+		}
+
+		report.Volumes = append(report.Volumes, v)
+		report.Total += v.Total
+	}
+
+	switch *cliCostFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		writeCostRows(report, func(row []string) {
+			w.Write(row)
+		})
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		writeCostRows(report, func(row []string) {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		})
+		return w.Flush()
+	}
+}
+
+// Helper function to render a cost report as rows: a header, then a row for
+// each volume, each tag value and the total.
+func writeCostRows(r *CostReport, write func([]string)) {
+	money := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	gibs := func(v int64) string {
+		return strconv.FormatFloat(float64(v)/gib, 'f', 2, 64)
+	}
+
+	write([]string{"TYPE", "NAME", "FILESYSTEM", "REGION", "STANDARD_GIB", "IA_GIB", "THROUGHPUT_MODE", "PROVISIONED_MIBPS", "STORAGE", "THROUGHPUT", "TOTAL"})
+	for _, v := range r.Volumes {
+		write([]string{"volume", v.Volume, v.FilesystemId, v.Region, gibs(v.StandardBytes), gibs(v.IABytes), v.ThroughputMode, strconv.FormatFloat(v.ProvisionedMibps, 'f', -1, 64), money(v.Storage), money(v.Throughput), money(v.Total)})
+	}
+
+	keys := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := make([]string, 0, len(r.Tags[k]))
+		for v := range r.Tags[k] {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			write([]string{"tag", k + "=" + v, "", "", "", "", "", "", "", "", money(r.Tags[k][v])})
+		}
+	}
+
+	write([]string{"total", "", "", "", "", "", "", "", "", "", money(r.Total)})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestEstimateCost(t *testing.T) {
+	c := NewConfig()
+	c.Prices[""] = Options{priceStandard: "0.50"}
+	c.Prices["eu-west-1"] = Options{priceStandard: "0.40", priceIA: "0.05"}
+
+	tests := []struct {
+		name       string
+		region     string
+		fs         *fileSystemDescription
+		standard   int64
+		ia         int64
+		storage    float64
+		throughput float64
+	}{
+		{
+			name:     "total size only",
+			region:   "us-east-1",
+			fs:       &fileSystemDescription{SizeInBytes: &fileSystemSize{Value: aws.Int64(10 * gib)}},
+			standard: 10 * gib,
+			storage:  10 * 0.50,
+		},
+		{
+			name:   "storage classes with regional prices",
+			region: "eu-west-1",
+			fs: &fileSystemDescription{SizeInBytes: &fileSystemSize{
+				Value:           aws.Int64(110 * gib),
+				ValueInStandard: aws.Int64(10 * gib),
+				ValueInIA:       aws.Int64(100 * gib),
+			}},
+			standard: 10 * gib,
+			ia:       100 * gib,
+			storage:  10*0.40 + 100*0.05,
+		},
+		{
+			name:   "provisioned throughput",
+			region: "us-east-1",
+			fs: &fileSystemDescription{
+				SizeInBytes:                  &fileSystemSize{Value: aws.Int64(1024 * gib)},
+				ThroughputMode:               aws.String("provisioned"),
+				ProvisionedThroughputInMibps: aws.Float64(100),
+			},
+			standard:   1024 * gib,
+			storage:    1024 * 0.50,
+			throughput: (100 - 50) * 6.00,
+		},
+		{
+			name:   "provisioned throughput included with the storage",
+			region: "us-east-1",
+			fs: &fileSystemDescription{
+				SizeInBytes:                  &fileSystemSize{Value: aws.Int64(4096 * gib)},
+				ThroughputMode:               aws.String("provisioned"),
+				ProvisionedThroughputInMibps: aws.Float64(100),
+			},
+			standard: 4096 * gib,
+			storage:  4096 * 0.50,
+		},
+		{
+			name:   "no size",
+			region: "us-east-1",
+			fs:     &fileSystemDescription{},
+		},
+	}
+
+	for _, tt := range tests {
+		v := EstimateCost(c, tt.region, tt.fs)
+		if v.StandardBytes != tt.standard || v.IABytes != tt.ia {
+			t.Errorf("%s: got %d standard and %d IA bytes, want %d and %d", tt.name, v.StandardBytes, v.IABytes, tt.standard, tt.ia)
+		}
+		if !near(v.Storage, tt.storage) || !near(v.Throughput, tt.throughput) || !near(v.Total, tt.storage+tt.throughput) {
+			t.Errorf("%s: got storage %.4f, throughput %.4f, total %.4f, want %.4f and %.4f", tt.name, v.Storage, v.Throughput, v.Total, tt.storage, tt.throughput)
+		}
+	}
+}
+
+func TestPrice(t *testing.T) {
+	c := NewConfig()
+	c.Prices[""] = Options{priceStandard: "0.50"}
+	c.Prices["eu-west-1"] = Options{priceStandard: "0.40"}
+
+	tests := []struct {
+		region, key string
+		want        float64
+	}{
+		{"eu-west-1", priceStandard, 0.40},
+		{"us-west-2", priceStandard, 0.50},
+		{"eu-west-1", priceIA, defaultPrices[priceIA]},
+	}
+	for _, tt := range tests {
+		if got := c.Price(tt.region, tt.key); !near(got, tt.want) {
+			t.Errorf("Price(%s, %s) = %f, want %f", tt.region, tt.key, got, tt.want)
+		}
+	}
+
+	for _, o := range []Options{{"standard": "0.30"}, {priceIA: "cheap"}, {priceIA: "-1"}} {
+		if err := ValidatePrices(o); err == nil {
+			t.Errorf("ValidatePrices(%v): got no error", o)
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
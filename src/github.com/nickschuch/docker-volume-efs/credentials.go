@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	optRoleArn    = "role_arn"
+	optExternalId = "external_id"
+
+	// How long assumed role credentials are valid for, and how long before
+	// they expire that they are refreshed.
+	roleDuration     = time.Hour
+	roleExpiryWindow = 5 * time.Minute
+)
+
+var (
+	// Options which decide the role the plugin assumes, so only the flags and
+	// the configuration (eg. a profile) can set them, never a volume's -o
+	// options. They aren't recorded as tags either.
+	credentialOptions = []string{optRoleArn, optExternalId}
+
+	cliRoleArn    = kingpin.Flag("role-arn", "IAM role to assume for EFS API calls (eg. in a shared storage account).").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_ROLE_ARN").String()
+	cliExternalId = kingpin.Flag("external-id", "External ID to use when assuming --role-arn.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_EXTERNAL_ID").String()
+
+	// The ID of this instance, used to identify its role sessions.
+	instanceId string
+
+	// Assumed role credentials, keyed by role and external ID, so they are
+	// shared by every client and only refreshed when they are about to expire.
+	roleCredentials   = make(map[string]*credentials.Credentials)
+	roleCredentialsMu sync.Mutex
+)
+
+// AssumeRoleProvider retrieves credentials by assuming an IAM role. Unlike
+// the vendored stscreds provider this supports an external ID.
+type AssumeRoleProvider struct {
+	credentials.Expiry
+
+	Client       *sts.STS
+	RoleARN      string
+	ExternalID   string
+	SessionName  string
+	Duration     time.Duration
+	ExpiryWindow time.Duration
+}
+
+// Retrieve assumes the role and returns the temporary credentials.
+func (p *AssumeRoleProvider) Retrieve() (credentials.Value, error) {
+	input := &sts.AssumeRoleInput{
+		DurationSeconds: aws.Int64(int64(p.Duration / time.Second)),
+		RoleArn:         aws.String(p.RoleARN),
+		RoleSessionName: aws.String(p.SessionName),
+	}
+	if p.ExternalID != "" {
+		input.ExternalId = aws.String(p.ExternalID)
+	}
+
+	resp, err := p.Client.AssumeRole(input)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("cannot assume role %s: %s", p.RoleARN, err)
+	}
+	p.SetExpiration(*resp.Credentials.Expiration, p.ExpiryWindow)
+
+	log.WithFields(log.Fields{
+		"role_arn":   p.RoleARN,
+		"session":    p.SessionName,
+		"expiration": resp.Credentials.Expiration.String(),
+	}).Info("Assumed role")
+
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+	}, nil
+}
+
+// Helper function to get the credentials for a volume. Returns nil (the
+// default credential chain) when the volume doesn't use a role.
+func VolumeCredentials(o Options) *credentials.Credentials {
+	role := o.Get(optRoleArn, "")
+	if role == "" {
+		return nil
+	}
+	return RoleCredentials(role, o.Get(optExternalId, ""))
+}
+
+// Helper function to get cached credentials for a role.
+func RoleCredentials(role, externalId string) *credentials.Credentials {
+	key := role + "|" + externalId
+
+	roleCredentialsMu.Lock()
+	defer roleCredentialsMu.Unlock()
+
+	if c, ok := roleCredentials[key]; ok {
+		return c
+	}
+
+	// The role is assumed with the credentials of this host.
+	client := sts.New(&aws.Config{})
+	LimitRequests(client.Service)
+
+	c := credentials.NewCredentials(&AssumeRoleProvider{
+		Client:       client,
+		RoleARN:      role,
+		ExternalID:   externalId,
+		SessionName:  SessionName(),
+		Duration:     roleDuration,
+		ExpiryWindow: roleExpiryWindow,
+	})
+	roleCredentials[key] = c
+	return c
+}
+
+// Helper function to get the role session name, which identifies this host
+// in the CloudTrail logs of the account which owns the role.
+func SessionName() string {
+	if instanceId == "" {
+		return fmt.Sprintf("docker-volume-efs-%d", time.Now().Unix())
+	}
+	return "docker-volume-efs-" + instanceId
+}
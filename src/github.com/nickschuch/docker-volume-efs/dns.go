@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+const (
+	// Mount with the DNS name of the filesystem instead of the IP address of
+	// its mount target: "regional" (or "true") for fs-xxxx.efs.<region>.amazonaws.com,
+	// or "az" for the name of the mount target in this availability zone.
+	optDNS = "dns"
+
+	dnsRegional = "regional"
+	dnsZone     = "az"
+
+	// How long to wait for a DNS name to resolve before mounting by IP.
+	dnsTimeout = 2 * time.Second
+)
+
+// Helper function to get the DNS mode of a volume, or an empty string to
+// mount by IP.
+func DNSMode(o Options) (string, error) {
+	switch v := o.Get(optDNS, ""); v {
+	case "", "false":
+		return "", nil
+	case "true", dnsRegional:
+		return dnsRegional, nil
+	case dnsZone:
+		return dnsZone, nil
+	default:
+		return "", fmt.Errorf("invalid %s: %s (false, regional, az)", optDNS, v)
+	}
+}
+
+// Helper function to get the address to mount a mount target with, and the
+// availability zone when the address is AZ-specific. DNS names are only used
+// when they resolve, as DNS might not be enabled for the VPC, otherwise the IP
+// address of the mount target is used.
+func MountAddress(l *log.Entry, m *efs.MountTargetDescription, region, zone string, o Options) (string, string, error) {
+	mode, err := DNSMode(o)
+	if err != nil || mode == "" {
+		return *m.IpAddress, "", err
+	}
+
+	name := fmt.Sprintf("%s.efs.%s.amazonaws.com", *m.FileSystemId, region)
+	if mode == dnsZone && zone != "" {
+		name = zone + "." + name
+	} else {
+		zone = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, name); err != nil {
+		l.WithFields(log.Fields{
+			"dns_name": name,
+			"error":    err,
+		}).Warn("Cannot resolve DNS name, mounting by IP")
+		metricDNSFallbacks.Inc()
+		return *m.IpAddress, "", nil
+	}
+
+	return name, zone, nil
+}
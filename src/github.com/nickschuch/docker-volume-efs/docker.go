@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/alecthomas/kingpin"
@@ -11,6 +17,63 @@ var (
 	cliDocker = kingpin.Flag("docker", "The Docker endpoint.").Default("unix:///var/run/docker.sock").OverrideDefaultFromEnvar("DOCKER_HOST").String()
 )
 
+// Container is a container as inspected through the Docker API. The vendored
+// go-dockerclient predates Mounts (Docker 1.8), which is the only place the
+// volumes given with --mount (eg. by Swarm services) are reported, so they are
+// decoded here as well.
+type Container struct {
+	docker.Container
+	Mounts []ContainerMount `json:"Mounts,omitempty"`
+}
+
+// ContainerMount is a volume or bind mount of a container.
+type ContainerMount struct {
+	Type        string `json:"Type,omitempty"`
+	Name        string `json:"Name,omitempty"`
+	Source      string `json:"Source,omitempty"`
+	Destination string `json:"Destination,omitempty"`
+	Driver      string `json:"Driver,omitempty"`
+}
+
+// Helper function to inspect a container, including its mounts.
+func InspectContainer(id string) (*Container, error) {
+	u, err := url.Parse(*cliDocker)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{}
+	base := "http://" + u.Host
+	switch u.Scheme {
+	case "unix":
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", u.Path)
+			},
+		}
+		base = "http://docker"
+	case "http", "https":
+		base = u.Scheme + "://" + u.Host
+	}
+
+	resp, err := client.Get(base + "/containers/" + url.PathEscape(id) + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot inspect container %s: %s", id, resp.Status)
+	}
+
+	c := &Container{}
+	if err := json.NewDecoder(resp.Body).Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Helper function to get the volumes used by the running containers, whether
+// they were given with -v or --mount.
 func GetDockerBinds() ([]string, error) {
 	var binds []string
 
@@ -25,16 +88,113 @@ func GetDockerBinds() ([]string, error) {
 	}
 
 	for _, c := range list {
-		container, err := client.InspectContainer(c.ID)
+		container, err := InspectContainer(c.ID)
 		if err != nil {
 			continue
 		}
 
-		for _, b := range container.HostConfig.Binds {
-			s := strings.Split(b, ":")
-			binds = append(binds, s[0])
-		}
+		// Swarm services use their volumes with --mount.
+		binds = append(binds, ContainerVolumes(container)...)
 	}
 
 	return binds, nil
 }
+
+// Helper function to determine if a volume driver is this plugin. A managed
+// plugin is named by its reference (eg. nickschuch/efs:latest) unless it was
+// installed with an alias.
+func IsPluginDriver(driver string) bool {
+	name := driver[strings.LastIndex(driver, "/")+1:]
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name == pluginId
+}
+
+// Helper function to get the volumes a container uses, whether they were given
+// with -v (binds) or --mount (and by Swarm services). Only volumes of this
+// plugin are returned from the mounts, daemons older than Docker 1.8 only
+// report binds.
+func ContainerVolumes(c *Container) []string {
+	var names []string
+
+	for _, m := range c.Mounts {
+		if m.Type != "" && m.Type != "volume" {
+			continue
+		}
+		if m.Name != "" && IsPluginDriver(m.Driver) {
+			names = append(names, m.Name)
+		}
+	}
+
+	if c.HostConfig != nil {
+		for _, b := range c.HostConfig.Binds {
+			names = append(names, strings.Split(b, ":")[0])
+		}
+	}
+
+	return names
+}
+
+// Helper function to get the containers (running or not) which use a volume.
+func ContainersUsing(n string) ([]*Container, error) {
+	var containers []*Container
+
+	client, err := docker.NewClient(*cliDocker)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range list {
+		container, err := InspectContainer(c.ID)
+		if err != nil {
+			continue
+		}
+
+		if Contains(ContainerVolumes(container), n) {
+			containers = append(containers, container)
+		}
+	}
+
+	return containers, nil
+}
+
+// Helper function to pause the running containers which use a volume, so it
+// isn't written to (eg. while it is copied). The returned function unpauses
+// them again.
+func PauseContainers(n string) (func(), error) {
+	client, err := docker.NewClient(*cliDocker)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := ContainersUsing(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var paused []string
+	unpause := func() {
+		for _, id := range paused {
+			client.UnpauseContainer(id)
+		}
+	}
+
+	for _, c := range containers {
+		if !c.State.Running || c.State.Paused {
+			continue
+		}
+		if err := client.PauseContainer(c.ID); err != nil {
+			unpause()
+			return nil, err
+		}
+		paused = append(paused, c.ID)
+	}
+
+	return unpause, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dockertest "github.com/fsouza/go-dockerclient/testing"
+)
+
+// fakeDocker is a fake Docker daemon which the Docker helpers talk to.
+type fakeDocker struct {
+	t          *testing.T
+	server     *dockertest.DockerServer
+	client     *docker.Client
+	containers chan *docker.Container
+	mounts     map[string][]ContainerMount
+}
+
+var inspectPath = regexp.MustCompile(`/containers/([^/]+)/json$`)
+
+// Helper function to start a fake Docker daemon and point --docker at it.
+func newFakeDocker(t *testing.T) *fakeDocker {
+	containers := make(chan *docker.Container, 16)
+	server, err := dockertest.NewServer("127.0.0.1:0", containers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := docker.NewClient(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PullImage(docker.PullImageOptions{Repository: "busybox"}, docker.AuthConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := *cliDocker
+	*cliDocker = server.URL()
+	t.Cleanup(func() {
+		*cliDocker = endpoint
+		server.Stop()
+	})
+
+	f := &fakeDocker{t: t, server: server, client: client, containers: containers, mounts: make(map[string][]ContainerMount)}
+	server.CustomHandler(inspectPath.String(), http.HandlerFunc(f.inspect))
+	return f
+}
+
+// The fake daemon predates mounts, so they are added to what it inspects.
+func (f *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	rec := httptest.NewRecorder()
+	f.server.DefaultHandler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+		return
+	}
+
+	c := &Container{}
+	if err := json.Unmarshal(rec.Body.Bytes(), c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Mounts = f.mounts[c.ID]
+	json.NewEncoder(w).Encode(c)
+}
+
+// Helper function to create a container which uses volumes with -v (binds)
+// or --mount (mounts), in a state.
+func (f *fakeDocker) create(name string, labels map[string]string, binds []string, mounts []ContainerMount, state docker.State) {
+	_, err := f.client.CreateContainer(docker.CreateContainerOptions{
+		Name:       name,
+		Config:     &docker.Config{Image: "busybox", Labels: labels},
+		HostConfig: &docker.HostConfig{Binds: binds},
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	// The fake daemon doesn't fill in the mounts, so we do.
+	c := <-f.containers
+	f.mounts[c.ID] = mounts
+	if err := f.server.MutateContainer(c.ID, state); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+var (
+	running  = docker.State{Running: true, StartedAt: time.Now()}
+	starting = docker.State{}
+	stopped  = docker.State{StartedAt: time.Now().Add(-time.Hour), FinishedAt: time.Now().Add(-time.Minute)}
+)
+
+func volumeMount(name, driver string) []ContainerMount {
+	return []ContainerMount{{Type: "volume", Name: name, Driver: driver, Destination: "/data"}}
+}
+
+func TestIsPluginDriver(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   bool
+	}{
+		{"efs", true},
+		{"nickschuch/efs", true},
+		{"nickschuch/efs:latest", true},
+		{"registry.example.com:5000/nickschuch/efs:1.0", true},
+		{"local", false},
+		{"efs2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsPluginDriver(tt.driver); got != tt.want {
+			t.Errorf("IsPluginDriver(%q) = %t, want %t", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestContainerVolumes(t *testing.T) {
+	tests := []struct {
+		name      string
+		container *Container
+		want      []string
+	}{
+		{
+			name:      "bind",
+			container: &Container{Container: docker.Container{HostConfig: &docker.HostConfig{Binds: []string{"foo:/data:ro"}}}},
+			want:      []string{"foo"},
+		},
+		{
+			name:      "mount",
+			container: &Container{Mounts: volumeMount("foo", "efs")},
+			want:      []string{"foo"},
+		},
+		{
+			name:      "mount of a managed plugin",
+			container: &Container{Mounts: volumeMount("foo", "nickschuch/efs:latest")},
+			want:      []string{"foo"},
+		},
+		{
+			name:      "mount of another driver",
+			container: &Container{Mounts: volumeMount("foo", "local")},
+		},
+		{
+			name:      "bind mount",
+			container: &Container{Mounts: []ContainerMount{{Type: "bind", Source: "/srv", Destination: "/data"}}},
+		},
+	}
+	for _, tt := range tests {
+		got := ContainerVolumes(tt.container)
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetDockerBinds(t *testing.T) {
+	f := newFakeDocker(t)
+	f.create("web", nil, []string{"foo:/data"}, nil, running)
+	// A task of a Swarm service.
+	f.create("app.1.abc", map[string]string{"com.docker.swarm.service.name": "app"}, nil, volumeMount("bar", "nickschuch/efs:latest"), running)
+	f.create("old", nil, []string{"baz:/data"}, volumeMount("qux", "efs"), stopped)
+
+	binds, err := GetDockerBinds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"foo", "bar"} {
+		if !Contains(binds, n) {
+			t.Errorf("%s is used by a running container but not in %v, it would be unmounted", n, binds)
+		}
+	}
+	for _, n := range []string{"baz", "qux"} {
+		if Contains(binds, n) {
+			t.Errorf("%s is only used by a stopped container but in %v", n, binds)
+		}
+	}
+}
+
+func TestInspectContainer(t *testing.T) {
+	f := newFakeDocker(t)
+	f.create("app.1.abc", nil, nil, volumeMount("bar", "efs"), running)
+
+	list, err := f.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("got %v, %v, want a container", list, err)
+	}
+
+	// The default endpoint is a unix socket.
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, f.server)
+	defer l.Close()
+
+	for _, endpoint := range []string{f.server.URL(), "unix://" + l.Addr().String()} {
+		*cliDocker = endpoint
+
+		c, err := InspectContainer(list[0].ID)
+		if err != nil {
+			t.Fatalf("%s: %s", endpoint, err)
+		}
+		if c.ID != list[0].ID || !c.State.Running || len(c.Mounts) != 1 || c.Mounts[0].Name != "bar" {
+			t.Errorf("%s: got %+v", endpoint, c)
+		}
+		if _, err := InspectContainer("missing"); err == nil {
+			t.Errorf("%s: got no error for a missing container", endpoint)
+		}
+	}
+}
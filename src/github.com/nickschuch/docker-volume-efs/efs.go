@@ -1,9 +1,11 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"sync"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/efs"
 )
@@ -12,64 +14,177 @@ const (
 	efsAvail = "available"
 )
 
-// Helper function to get the EFS endpoint for mounting.
-func GetEFS(e *efs.EFS, s string, n string) (string, error) {
+var (
+	// Volumes whose EFS resources are being created (how many times), and
+	// when each was last provisioned, for the watchdog.
+	provisioning   = make(map[string]int)
+	provisioned    = make(map[string]time.Time)
+	provisioningMu sync.Mutex
+)
+
+// Helper function to record that the EFS resources of a volume are being
+// created, which takes minutes. Returns a function to call once they are.
+func TrackProvisioning(n string) func() {
+	provisioningMu.Lock()
+	defer provisioningMu.Unlock()
+
+	provisioning[n]++
+	return func() {
+		provisioningMu.Lock()
+		defer provisioningMu.Unlock()
+
+		if provisioning[n]--; provisioning[n] <= 0 {
+			delete(provisioning, n)
+		}
+		provisioned[n] = time.Now()
+	}
+}
+
+// Helper function to determine if the EFS resources of a volume are being
+// created, or when they last were.
+func Provisioned(n string) (bool, time.Time) {
+	provisioningMu.Lock()
+	defer provisioningMu.Unlock()
+
+	return provisioning[n] > 0, provisioned[n]
+}
+
+// Helper function to get the EFS mount target for mounting. The filesystem is
+// created from the volume options if it doesn't exist, and mount targets are
+// created in each of the given subnets when the filesystem doesn't have one.
+func GetEFS(l *log.Entry, e *efs.EFS, p *Policy, subnets []string, o Options, n string) (*efs.MountTargetDescription, error) {
+	if id := o.Get(optFsid, ""); id != "" {
+		return GetExternalEFS(l, e, subnets, id)
+	}
+
+	security := o.List(optSecurityGroups)
+	t := p.Token(n)
+
 	// Check if the EFS Filesystem already exists.
-	fs, err := DescribeFilesystem(e, n)
+	fs, err := DescribeFilesystem(e, t)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if len(fs.FileSystems) > 0 {
+		l = l.WithField("filesystem_id", *fs.FileSystems[0].FileSystemId)
+
 		mnt, err := DescribeMountTarget(e, *fs.FileSystems[0].FileSystemId)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		// This means we do have a mount target and we don't need to worry about
 		// creating one.
 		if len(mnt.MountTargets) > 0 {
-			return *mnt.MountTargets[0].IpAddress, nil
+			m := PickMountTarget(mnt.MountTargets, subnets)
+			l.WithField("mount_target", *m.IpAddress).Debug("Using existing EFS Filesystem")
+			return m, nil
 		}
 
 		// In the off chance that we find outselves in a position where we don't have
 		// a mount target for this EFS Filesystem we create one.
-		newMnt, err := CreateMountTarget(e, *fs.FileSystems[0].FileSystemId, s)
+		defer TrackProvisioning(n)()
+		newMnt, err := CreateMountTargets(e, *fs.FileSystems[0].FileSystemId, subnets, security)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		log.Printf("Using existing EFS Mount point: %s", *newMnt.IpAddress)
-		return *newMnt.IpAddress, nil
+		l.WithField("mount_target", *newMnt.IpAddress).Info("Created EFS Mount point for existing EFS Filesystem")
+		return newMnt, nil
 	}
 
 	// We now have the go ahead to create one instead.
-	newFs, err := CreateFilesystem(e, n)
+	if err := p.Limit(e); err != nil {
+		return nil, err
+	}
+	defer TrackProvisioning(n)()
+	newFs, err := CreateFilesystem(e, p, n, o)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	newMnt, err := CreateMountTarget(e, *newFs.FileSystemId, s)
+	newMnt, err := CreateMountTargets(e, *newFs.FileSystemId, subnets, security)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	log.Printf("Created new EFS Filesytem with mount point: %s", *newMnt.IpAddress)
-	return *newMnt.IpAddress, nil
+	l.WithFields(log.Fields{
+		"filesystem_id": *newFs.FileSystemId,
+		"mount_target":  *newMnt.IpAddress,
+	}).Info("Created new EFS Filesystem")
+	return newMnt, nil
+}
+
+// Helper function to pick the mount target to use, preferring one in the
+// first matching subnet.
+func PickMountTarget(mnts []*efs.MountTargetDescription, subnets []string) *efs.MountTargetDescription {
+	for _, s := range subnets {
+		for _, m := range mnts {
+			if *m.SubnetId == s {
+				return m
+			}
+		}
+	}
+	return mnts[0]
 }
 
-// Helper function to create an EFS Filesystem.
-func CreateFilesystem(e *efs.EFS, n string) (*efs.FileSystemDescription, error) {
-	createParams := &efs.CreateFileSystemInput{
-		CreationToken: aws.String(n),
+// Helper function to create an EFS Mount target in each subnet. Returns the
+// mount target in the first subnet.
+func CreateMountTargets(e *efs.EFS, i string, subnets []string, security []string) (*efs.MountTargetDescription, error) {
+	if len(subnets) == 0 {
+		return nil, errors.New("No subnets to create an EFS Mount point in")
 	}
-	createResp, err := e.CreateFileSystem(createParams)
+
+	var first *efs.MountTargetDescription
+	for _, s := range subnets {
+		mnt, err := CreateMountTarget(e, i, s, security)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = mnt
+		}
+	}
+
+	return first, nil
+}
+
+// Helper function to create an EFS Filesystem from the volume options. It is
+// marked as created by the plugin when it is created, so it is never found
+// without the mark.
+func CreateFilesystem(e *efs.EFS, p *Policy, n string, o Options) (*efs.FileSystemDescription, error) {
+	t := p.Token(n)
+	createParams, err := FilesystemInput(t, o)
 	if err != nil {
 		return nil, err
 	}
+	createParams.Tags = p.Tags()
+
+	// The CreationToken makes this idempotent. If an earlier attempt created
+	// the filesystem (eg. the response was lost), use it.
+	createResp, err := createFileSystem(e, createParams)
+	if IsErrorCode(err, "FileSystemAlreadyExists") {
+		fs, derr := DescribeFilesystem(e, t)
+		if derr != nil || len(fs.FileSystems) == 0 {
+			return nil, err
+		}
+		tags, derr := DescribeTags(e, *fs.FileSystems[0].FileSystemId)
+		if derr != nil {
+			return nil, err
+		}
+		if !p.Managed(fs.FileSystems[0], tags) {
+			return nil, p.NotManaged(*fs.FileSystems[0].FileSystemId, n)
+		}
+		createResp = fs.FileSystems[0]
+	} else if err != nil {
+		return nil, err
+	}
 
 	// Wait for the filesystem to become available.
+	start := time.Now()
+	defer metricProvisionWait.Since(start, "filesystem")
 	for {
-		fs, err := DescribeFilesystem(e, n)
+		fs, err := DescribeFilesystem(e, t)
 		if err != nil {
 			return nil, err
 		}
@@ -81,49 +196,106 @@ func CreateFilesystem(e *efs.EFS, n string) (*efs.FileSystemDescription, error)
 		time.Sleep(10 * time.Second)
 	}
 
+	// Name the filesystem after the volume so it can be found in the console.
+	if err := TagFilesystem(e, *createResp.FileSystemId, []*efs.Tag{
+		{Key: aws.String(tagName), Value: aws.String(n)},
+	}); err != nil {
+		return nil, err
+	}
+
+	if lifecycle := LifecycleInput(*createResp.FileSystemId, o); lifecycle != nil {
+		if err := putLifecycleConfiguration(e, lifecycle); err != nil {
+			return nil, err
+		}
+	}
+
 	return createResp, nil
 }
 
+// Helper function to add tags to an EFS Filesystem.
+func TagFilesystem(e *efs.EFS, i string, tags []*efs.Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := e.CreateTags(&efs.CreateTagsInput{
+		FileSystemId: aws.String(i),
+		Tags:         tags,
+	})
+	return err
+}
+
+// Helper function to remove tags from an EFS Filesystem.
+func UntagFilesystem(e *efs.EFS, i string, keys ...string) error {
+	_, err := e.DeleteTags(&efs.DeleteTagsInput{
+		FileSystemId: aws.String(i),
+		TagKeys:      aws.StringSlice(keys),
+	})
+	return err
+}
+
+// Helper function to get the tags of an EFS Filesystem.
+func DescribeTags(e *efs.EFS, i string) ([]*efs.Tag, error) {
+	resp, err := e.DescribeTags(&efs.DescribeTagsInput{
+		FileSystemId: aws.String(i),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Tags, nil
+}
+
 // Helper function to describe EFS Filesystems.
-func DescribeFilesystem(e *efs.EFS, n string) (*efs.DescribeFileSystemsOutput, error) {
+func DescribeFilesystem(e *efs.EFS, t string) (*efs.DescribeFileSystemsOutput, error) {
 	params := &efs.DescribeFileSystemsInput{
-		CreationToken: aws.String(n),
+		CreationToken: aws.String(t),
 	}
 	return e.DescribeFileSystems(params)
 }
 
 // Helper function to create an EFS Mount target.
-func CreateMountTarget(e *efs.EFS, i string, s string) (*efs.MountTargetDescription, error) {
-	var security []*string
+func CreateMountTarget(e *efs.EFS, i string, s string, security []string) (*efs.MountTargetDescription, error) {
+	params := &efs.CreateMountTargetInput{
+		FileSystemId: aws.String(i),
+		SubnetId:     aws.String(s),
+	}
 
-	// Determine if we need to assign a security group to this mount point, otherwise defer
+	// Determine if we need to assign security groups to this mount point, otherwise defer
 	// to the default group.
-	if *cliSecurity != "" {
-		security = []*string{
-			cliSecurity,
-		}
+	if len(security) > 0 {
+		params.SecurityGroups = aws.StringSlice(security)
 	}
 
-	params := &efs.CreateMountTargetInput{
-		FileSystemId:   aws.String(i),
-		SubnetId:       aws.String(s),
-		SecurityGroups: security,
-	}
+	// CreateMountTarget has no idempotency token, but there can only be one
+	// mount target per availability zone. If an earlier attempt created it
+	// (eg. the response was lost), use it.
 	resp, err := e.CreateMountTarget(params)
-	if err != nil {
+	if IsErrorCode(err, "MountTargetConflict") {
+		mnt, derr := DescribeMountTarget(e, i)
+		if derr != nil {
+			return nil, err
+		}
+		for _, m := range mnt.MountTargets {
+			if *m.SubnetId == s {
+				resp = m
+			}
+		}
+		if resp == nil {
+			return nil, err
+		}
+	} else if err != nil {
 		return nil, err
 	}
 
 	// Wait for the mount point to become available.
+	start := time.Now()
+	defer metricProvisionWait.Since(start, "mount_target")
 	for {
 		mnt, err := DescribeMountTarget(e, i)
 		if err != nil {
 			return nil, err
 		}
-		if len(mnt.MountTargets) > 0 {
-			if *mnt.MountTargets[0].LifeCycleState == efsAvail {
-				break
-			}
+		if available(mnt.MountTargets, *resp.MountTargetId) {
+			break
 		}
 		time.Sleep(10 * time.Second)
 	}
@@ -131,6 +303,16 @@ func CreateMountTarget(e *efs.EFS, i string, s string) (*efs.MountTargetDescript
 	return resp, nil
 }
 
+// Helper function to check if a mount target is available.
+func available(mnts []*efs.MountTargetDescription, id string) bool {
+	for _, m := range mnts {
+		if *m.MountTargetId == id {
+			return *m.LifeCycleState == efsAvail
+		}
+	}
+	return false
+}
+
 // Helper function to describe an EFS Mount target.
 func DescribeMountTarget(e *efs.EFS, i string) (*efs.DescribeMountTargetsOutput, error) {
 	params := &efs.DescribeMountTargetsInput{
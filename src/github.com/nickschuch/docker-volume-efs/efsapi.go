@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// The vendored EFS client predates performance and throughput modes,
+// encryption, lifecycle management, backup policies and storage classes.
+// These operations are sent through the same client (so signing, retries and
+// instrumentation still apply) but with shapes which include the newer
+// fields.
+
+type createFileSystemInput struct {
+	CreationToken                *string    `type:"string" required:"true"`
+	PerformanceMode              *string    `type:"string"`
+	ThroughputMode               *string    `type:"string"`
+	ProvisionedThroughputInMibps *float64   `type:"double"`
+	Encrypted                    *bool      `type:"boolean"`
+	KmsKeyId                     *string    `type:"string"`
+	Tags                         []*efs.Tag `type:"list"`
+}
+
+type lifecyclePolicy struct {
+	TransitionToIA *string `type:"string"`
+}
+
+type putLifecycleConfigurationInput struct {
+	FileSystemId      *string            `location:"uri" locationName:"FileSystemId" type:"string" required:"true"`
+	LifecyclePolicies []*lifecyclePolicy `type:"list" required:"true"`
+}
+
+type putLifecycleConfigurationOutput struct {
+	LifecyclePolicies []*lifecyclePolicy `type:"list"`
+}
+
+// Helper function to create an EFS Filesystem with the newer API fields.
+func createFileSystem(e *efs.EFS, input *createFileSystemInput) (*efs.FileSystemDescription, error) {
+	op := &request.Operation{
+		Name:       "CreateFileSystem",
+		HTTPMethod: "POST",
+		HTTPPath:   "/2015-02-01/file-systems",
+	}
+	output := &efs.FileSystemDescription{}
+	req := e.NewRequest(op, input, output)
+	return output, req.Send()
+}
+
+// Helper function to set the lifecycle policies of an EFS Filesystem.
+func putLifecycleConfiguration(e *efs.EFS, input *putLifecycleConfigurationInput) error {
+	op := &request.Operation{
+		Name:       "PutLifecycleConfiguration",
+		HTTPMethod: "PUT",
+		HTTPPath:   "/2015-02-01/file-systems/{FileSystemId}/lifecycle-configuration",
+	}
+	req := e.NewRequest(op, input, &putLifecycleConfigurationOutput{})
+	return req.Send()
+}
+
+type backupPolicy struct {
+	Status *string `type:"string" required:"true"`
+}
+
+type putBackupPolicyInput struct {
+	FileSystemId *string       `location:"uri" locationName:"FileSystemId" type:"string" required:"true"`
+	BackupPolicy *backupPolicy `type:"structure" required:"true"`
+}
+
+type describeBackupPolicyInput struct {
+	FileSystemId *string `location:"uri" locationName:"FileSystemId" type:"string" required:"true"`
+}
+
+type backupPolicyOutput struct {
+	BackupPolicy *backupPolicy `type:"structure"`
+}
+
+// Helper function to set the automatic backup policy of an EFS Filesystem.
+func putBackupPolicy(e *efs.EFS, input *putBackupPolicyInput) error {
+	op := &request.Operation{
+		Name:       "PutBackupPolicy",
+		HTTPMethod: "PUT",
+		HTTPPath:   "/2015-02-01/file-systems/{FileSystemId}/backup-policy",
+	}
+	req := e.NewRequest(op, input, &backupPolicyOutput{})
+	return req.Send()
+}
+
+// Helper function to get the automatic backup policy of an EFS Filesystem.
+func describeBackupPolicy(e *efs.EFS, input *describeBackupPolicyInput) (*backupPolicyOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeBackupPolicy",
+		HTTPMethod: "GET",
+		HTTPPath:   "/2015-02-01/file-systems/{FileSystemId}/backup-policy",
+	}
+	output := &backupPolicyOutput{}
+	req := e.NewRequest(op, input, output)
+	return output, req.Send()
+}
+
+type fileSystemSize struct {
+	Value           *int64 `type:"long" required:"true"`
+	ValueInIA       *int64 `type:"long"`
+	ValueInStandard *int64 `type:"long"`
+}
+
+type fileSystemDescription struct {
+	FileSystemId                 *string         `type:"string" required:"true"`
+	SizeInBytes                  *fileSystemSize `type:"structure" required:"true"`
+	ThroughputMode               *string         `type:"string"`
+	ProvisionedThroughputInMibps *float64        `type:"double"`
+}
+
+type describeFileSystemsOutput struct {
+	FileSystems []*fileSystemDescription `type:"list"`
+}
+
+type listFileSystemsInput struct {
+	Marker *string `location:"querystring" locationName:"Marker" type:"string"`
+}
+
+type taggedFileSystem struct {
+	FileSystemId  *string             `type:"string" required:"true"`
+	CreationToken *string             `type:"string" required:"true"`
+	Name          *string             `type:"string"`
+	SizeInBytes   *efs.FileSystemSize `type:"structure"`
+	Tags          []*efs.Tag          `type:"list"`
+}
+
+// Helper function to get the description of a listed EFS Filesystem.
+func (fs *taggedFileSystem) Description() *efs.FileSystemDescription {
+	return &efs.FileSystemDescription{
+		FileSystemId:  fs.FileSystemId,
+		CreationToken: fs.CreationToken,
+		Name:          fs.Name,
+		SizeInBytes:   fs.SizeInBytes,
+	}
+}
+
+type listFileSystemsOutput struct {
+	FileSystems []*taggedFileSystem `type:"list"`
+	NextMarker  *string             `type:"string"`
+}
+
+// Helper function to list the EFS Filesystems with their tags, which saves a
+// DescribeTags call for each of them.
+func listFileSystems(e *efs.EFS) ([]*taggedFileSystem, error) {
+	op := &request.Operation{
+		Name:       "DescribeFileSystems",
+		HTTPMethod: "GET",
+		HTTPPath:   "/2015-02-01/file-systems",
+	}
+
+	var filesystems []*taggedFileSystem
+	input := &listFileSystemsInput{}
+	for {
+		output := &listFileSystemsOutput{}
+		if err := e.NewRequest(op, input, output).Send(); err != nil {
+			return nil, err
+		}
+		filesystems = append(filesystems, output.FileSystems...)
+
+		if aws.StringValue(output.NextMarker) == "" {
+			return filesystems, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+// Helper function to describe an EFS Filesystem, including its size by
+// storage class and its throughput mode.
+func describeFileSystem(e *efs.EFS, id *string) (*fileSystemDescription, error) {
+	op := &request.Operation{
+		Name:       "DescribeFileSystems",
+		HTTPMethod: "GET",
+		HTTPPath:   "/2015-02-01/file-systems",
+	}
+	output := &describeFileSystemsOutput{}
+	req := e.NewRequest(op, &efs.DescribeFileSystemsInput{FileSystemId: id}, output)
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	if len(output.FileSystems) == 0 {
+		return nil, fmt.Errorf("filesystem %s does not exist", aws.StringValue(id))
+	}
+	return output.FileSystems[0], nil
+}
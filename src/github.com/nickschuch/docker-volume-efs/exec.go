@@ -1,19 +1,36 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"fmt"
 	"os/exec"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
 )
 
-// Helper function to execute a command.
+// Helper function to execute a command. Output is always captured so that
+// failures (eg. mount and umount) can report what went wrong.
 func Exec(exe string, args ...string) error {
+	var stdout, stderr bytes.Buffer
+
 	cmd := exec.Command(exe, args...)
-	if *cliVerbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	if err := cmd.Run(); err != nil {
-		return err
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	log.WithFields(log.Fields{
+		"command": exe,
+		"args":    strings.Join(args, " "),
+		"stdout":  strings.TrimSpace(stdout.String()),
+		"stderr":  strings.TrimSpace(stderr.String()),
+	}).Debug("Executed command")
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s %s: %s: %s", exe, strings.Join(args, " "), err, msg)
+		}
+		return fmt.Errorf("%s %s: %s", exe, strings.Join(args, " "), err)
 	}
 	return nil
 }
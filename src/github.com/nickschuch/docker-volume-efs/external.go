@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// External volumes use an existing EFS Filesystem (eg. one created by
+// Terraform) instead of one created by this plugin. The plugin never creates
+// or deletes these filesystems, or their mount targets.
+
+const (
+	optFsid = "fsid"
+
+	// Records which volume adopted an EFS Filesystem with -o fsid, so other
+	// hosts can find it.
+	tagVolume = tagPrefix + "volume"
+)
+
+var (
+	fsidRegex = regexp.MustCompile(`^fs-[0-9a-f]{8,17}$`)
+
+	// The EFS Filesystems adopted by volumes, by the client configuration
+	// (account and region) they were found with.
+	adoptions   = make(map[*aws.Config]adoptionIndex)
+	adoptionsMu sync.Mutex
+)
+
+type adoptionIndex struct {
+	volumes map[string]string
+	expires time.Time
+}
+
+// Helper function to check the format of an EFS Filesystem ID.
+func ValidateFsid(id string) error {
+	if !fsidRegex.MatchString(id) {
+		return fmt.Errorf("invalid %s: %s (eg. fs-12345678)", optFsid, id)
+	}
+	return nil
+}
+
+// Helper function to find the EFS Filesystem of a volume (and its tags): the
+// filesystem it is bound to, the filesystem created for it, or a filesystem
+// adopted with -o fsid. Returns nil if there isn't one.
+func FindFilesystem(e *efs.EFS, p *Policy, fsid, n string) (*efs.FileSystemDescription, []*efs.Tag, error) {
+	var fs *efs.FileSystemDescription
+	var err error
+	token := false
+
+	if fsid != "" {
+		fs, err = DescribeFilesystemId(e, fsid)
+	} else {
+		var resp *efs.DescribeFileSystemsOutput
+		resp, err = DescribeFilesystem(e, p.Token(n))
+		if err == nil && len(resp.FileSystems) > 0 {
+			fs, token = resp.FileSystems[0], true
+		} else if err == nil {
+			fs, err = AdoptedFilesystem(e, p, n)
+		}
+	}
+	if err != nil || fs == nil {
+		return nil, nil, err
+	}
+
+	tags, err := DescribeTags(e, *fs.FileSystemId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Another filesystem with the same CreationToken (eg. a Terraform one) isn't
+	// the volume's.
+	if token && !p.Managed(fs, tags) {
+		return nil, nil, p.NotManaged(*fs.FileSystemId, n)
+	}
+	return fs, tags, nil
+}
+
+// Helper function to describe an EFS Filesystem by ID. Returns nil if it
+// doesn't exist.
+func DescribeFilesystemId(e *efs.EFS, id string) (*efs.FileSystemDescription, error) {
+	resp, err := e.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		FileSystemId: aws.String(id),
+	})
+	if err, ok := err.(awserr.Error); ok && err.Code() == "FileSystemNotFound" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.FileSystems) == 0 {
+		return nil, nil
+	}
+	return resp.FileSystems[0], nil
+}
+
+// Helper function to find an EFS Filesystem adopted by a volume with -o fsid.
+// Only filesystems which weren't created by the plugin can be adopted.
+func AdoptedFilesystem(e *efs.EFS, p *Policy, n string) (*efs.FileSystemDescription, error) {
+	id, err := AdoptedFilesystemId(e, p, n)
+	if err != nil || id == "" {
+		return nil, err
+	}
+	return DescribeFilesystemId(e, id)
+}
+
+// Helper function to get the ID of the EFS Filesystem adopted by a volume, or
+// an empty string if there isn't one. The filesystems adopted in each account
+// and region (and the volumes which didn't adopt one) are cached for
+// --mount-cache-ttl, so looking up volumes which don't exist (eg. by Get)
+// doesn't list every filesystem each time.
+func AdoptedFilesystemId(e *efs.EFS, p *Policy, n string) (string, error) {
+	adoptionsMu.Lock()
+	defer adoptionsMu.Unlock()
+
+	// Clients for the same account and region share their configuration.
+	a, ok := adoptions[e.Config]
+	if !ok || time.Now().After(a.expires) {
+		filesystems, err := listFileSystems(e)
+		if err != nil {
+			return "", err
+		}
+
+		a = adoptionIndex{
+			volumes: make(map[string]string),
+			expires: time.Now().Add(*cliMountCacheTTL),
+		}
+		for _, fs := range filesystems {
+			if _, ok := Marked(fs.Tags); ok {
+				continue
+			}
+			for _, t := range fs.Tags {
+				if aws.StringValue(t.Key) == tagVolume {
+					a.volumes[aws.StringValue(t.Value)] = *fs.FileSystemId
+				}
+			}
+		}
+		adoptions[e.Config] = a
+	}
+
+	return a.volumes[n], nil
+}
+
+// Helper function to record that a volume adopted an EFS Filesystem, so it is
+// found before the cached adoptions expire.
+func RecordAdoption(e *efs.EFS, n, id string) {
+	adoptionsMu.Lock()
+	defer adoptionsMu.Unlock()
+
+	if a, ok := adoptions[e.Config]; ok {
+		a.volumes[n] = id
+	}
+}
+
+// Helper function to forget the cached adoptions (eg. because the
+// configuration, and so the namespace, was reloaded).
+func FlushAdoptions() {
+	adoptionsMu.Lock()
+	defer adoptionsMu.Unlock()
+
+	adoptions = make(map[*aws.Config]adoptionIndex)
+}
+
+// Helper function to check that an EFS Filesystem can be adopted by a volume
+// with -o fsid. Only the filesystems listed in adopt_fsids can be adopted,
+// and never ones bound to another volume in the configuration or created by
+// the plugin (in any namespace). Returns the tag which binds it to the
+// volume, or nothing if it already is (or it is bound in the configuration).
+// No other tags are written to external filesystems.
+func AdoptionTags(e *efs.EFS, c *Config, id, n string, explicit Options) ([]*efs.Tag, error) {
+	if _, ok := explicit[optFsid]; !ok {
+		return nil, nil
+	}
+	for k := range explicit {
+		if k != optFsid {
+			return nil, fmt.Errorf("%s can't be given with %s, set it in a [volume \"%s\"] section instead", k, optFsid, n)
+		}
+	}
+
+	if bound := c.Volumes[n].Get(optFsid, ""); bound != "" {
+		if bound != id {
+			return nil, fmt.Errorf("volume %s is bound to EFS Filesystem %s in the configuration", n, bound)
+		}
+		return nil, nil
+	}
+	for name, o := range c.Volumes {
+		if o.Get(optFsid, "") == id {
+			return nil, fmt.Errorf("EFS Filesystem %s is bound to volume %s in the configuration", id, name)
+		}
+	}
+	if !Contains(c.Policy.Adoptable, id) {
+		return nil, fmt.Errorf("EFS Filesystem %s can't be adopted: it is not in %s", id, optAdoptFsids)
+	}
+
+	fs, err := DescribeFilesystemId(e, id)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return nil, fmt.Errorf("EFS Filesystem %s does not exist in %s", id, aws.StringValue(e.Config.Region))
+	}
+	if ns, ok := TokenNamespace(aws.StringValue(fs.CreationToken)); ok {
+		return nil, fmt.Errorf("EFS Filesystem %s has the CreationToken of a volume in namespace %s and can't be adopted", id, ns)
+	}
+
+	tags, err := DescribeTags(e, id)
+	if err != nil {
+		return nil, err
+	}
+	if ns, ok := Marked(tags); ok {
+		return nil, fmt.Errorf("EFS Filesystem %s was created by the plugin (in namespace %q) and can't be adopted", id, ns)
+	}
+	adopted := false
+	for _, t := range tags {
+		k := aws.StringValue(t.Key)
+		if !strings.HasPrefix(k, tagPrefix) {
+			continue
+		}
+		if k != tagVolume {
+			return nil, fmt.Errorf("EFS Filesystem %s has the %s tag of a volume and can't be adopted", id, k)
+		}
+		if v := aws.StringValue(t.Value); v != n {
+			return nil, fmt.Errorf("EFS Filesystem %s is already adopted by volume %s", id, v)
+		}
+		adopted = true
+	}
+	if adopted {
+		return nil, nil
+	}
+
+	return []*efs.Tag{{Key: aws.String(tagVolume), Value: aws.String(n)}}, nil
+}
+
+// Helper function to get the EFS mount target of an external EFS Filesystem.
+func GetExternalEFS(l *log.Entry, e *efs.EFS, subnets []string, id string) (*efs.MountTargetDescription, error) {
+	fs, err := DescribeFilesystemId(e, id)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return nil, fmt.Errorf("EFS Filesystem %s does not exist in %s", id, aws.StringValue(e.Config.Region))
+	}
+	if *fs.LifeCycleState != efsAvail {
+		return nil, fmt.Errorf("EFS Filesystem %s is %s", id, *fs.LifeCycleState)
+	}
+
+	mnt, err := DescribeMountTarget(e, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(mnt.MountTargets) == 0 {
+		return nil, fmt.Errorf("EFS Filesystem %s has no mount targets", id)
+	}
+
+	m := PickMountTarget(mnt.MountTargets, subnets)
+	l.WithFields(log.Fields{
+		"filesystem_id": id,
+		"mount_target":  *m.IpAddress,
+	}).Debug("Using external EFS Filesystem")
+	return m, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// Helper function to start a fake EFS API which describes filesystems, their
+// tags and a mount target for each, and counts the requests it is sent.
+func newFakeEFS(t *testing.T, filesystems []*taggedFileSystem) (*efs.EFS, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if strings.HasPrefix(r.URL.Path, "/2015-02-01/tags/") {
+			id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/2015-02-01/tags/"), "/")
+			out := efs.DescribeTagsOutput{}
+			for _, fs := range filesystems {
+				if *fs.FileSystemId == id {
+					out.Tags = fs.Tags
+				}
+			}
+			json.NewEncoder(w).Encode(out)
+			return
+		}
+
+		q := r.URL.Query()
+		if r.URL.Path == "/2015-02-01/mount-targets" {
+			out := efs.DescribeMountTargetsOutput{}
+			for _, fs := range filesystems {
+				if *fs.FileSystemId == q.Get("FileSystemId") {
+					out.MountTargets = append(out.MountTargets, &efs.MountTargetDescription{
+						FileSystemId:   fs.FileSystemId,
+						MountTargetId:  aws.String("fsmt-" + strings.TrimPrefix(*fs.FileSystemId, "fs-")),
+						SubnetId:       aws.String("subnet-11111111"),
+						IpAddress:      aws.String("10.0.0.1"),
+						LifeCycleState: aws.String(efsAvail),
+					})
+				}
+			}
+			json.NewEncoder(w).Encode(out)
+			return
+		}
+
+		out := listFileSystemsOutput{}
+		for _, fs := range filesystems {
+			if id := q.Get("FileSystemId"); id != "" && *fs.FileSystemId != id {
+				continue
+			}
+			if t := q.Get("CreationToken"); t != "" && *fs.CreationToken != t {
+				continue
+			}
+			out.FileSystems = append(out.FileSystems, fs)
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(FlushAdoptions)
+
+	ttl := *cliMountCacheTTL
+	*cliMountCacheTTL = time.Minute
+	t.Cleanup(func() { *cliMountCacheTTL = ttl })
+
+	e := efs.New(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	return e, &requests
+}
+
+func TestAdoptedFilesystem(t *testing.T) {
+	p := &Policy{}
+	tag := func(v string) []*efs.Tag {
+		return []*efs.Tag{{Key: aws.String(tagVolume), Value: aws.String(v)}}
+	}
+	e, requests := newFakeEFS(t, []*taggedFileSystem{
+		{FileSystemId: aws.String("fs-11111111"), CreationToken: aws.String("terraform-20240101120000000000000001"), Tags: tag("foo")},
+		{FileSystemId: aws.String("fs-22222222"), CreationToken: aws.String(p.Token("bar")), Name: aws.String("bar"), Tags: append(tag("bar"), p.Tags()...)},
+		{FileSystemId: aws.String("fs-33333333"), CreationToken: aws.String("console-1f0e7a52-3c4b-4d1e-9a8f-2b6c5d4e3f21")},
+	})
+
+	fs, err := AdoptedFilesystem(e, p, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs == nil || *fs.FileSystemId != "fs-11111111" {
+		t.Errorf("foo: got %v, want fs-11111111", fs)
+	}
+
+	// Filesystems created by the plugin can't be adopted.
+	if fs, err := AdoptedFilesystem(e, p, "bar"); err != nil || fs != nil {
+		t.Errorf("bar: got %v, %v, want nothing", fs, err)
+	}
+
+	// Volumes which didn't adopt a filesystem are cached too.
+	n := *requests
+	for i := 0; i < 3; i++ {
+		if fs, err := AdoptedFilesystem(e, p, "missing"); err != nil || fs != nil {
+			t.Errorf("missing: got %v, %v, want nothing", fs, err)
+		}
+	}
+	if *requests != n {
+		t.Errorf("looking up a missing volume sent %d requests, want 0", *requests-n)
+	}
+
+	// Adoptions are found before the cache expires.
+	RecordAdoption(e, "baz", "fs-33333333")
+	if id, _ := AdoptedFilesystemId(e, p, "baz"); id != "fs-33333333" {
+		t.Errorf("baz: got %q, want fs-33333333", id)
+	}
+}
+
+func TestAdoptionTags(t *testing.T) {
+	p := &Policy{}
+	prod := &Policy{Namespace: "prod"}
+	volume := func(n string) []*efs.Tag {
+		return []*efs.Tag{{Key: aws.String(tagVolume), Value: aws.String(n)}}
+	}
+	e, _ := newFakeEFS(t, []*taggedFileSystem{
+		{FileSystemId: aws.String("fs-11111111"), CreationToken: aws.String("terraform-20240101120000000000000001")},
+		{FileSystemId: aws.String("fs-22222222"), CreationToken: aws.String("console-1f0e7a52-3c4b-4d1e-9a8f-2b6c5d4e3f21")},
+		{FileSystemId: aws.String("fs-33333333"), CreationToken: aws.String(p.Token("bar")), Tags: p.Tags()},
+		{FileSystemId: aws.String("fs-44444444"), CreationToken: aws.String(prod.Token("bar")), Tags: prod.Tags()},
+		{FileSystemId: aws.String("fs-55555555"), CreationToken: aws.String(prod.Token("baz"))},
+		{FileSystemId: aws.String("fs-66666666"), CreationToken: aws.String("terraform-20240101120000000000000002"), Tags: OptionTags(Options{optOwner: "team-a"})},
+		{FileSystemId: aws.String("fs-77777777"), CreationToken: aws.String("terraform-20240101120000000000000003"), Tags: volume("foo")},
+		{FileSystemId: aws.String("fs-88888888"), CreationToken: aws.String("terraform-20240101120000000000000004"), Tags: volume("other")},
+		{FileSystemId: aws.String("fs-99999999"), CreationToken: aws.String("terraform-20240101120000000000000005")},
+	})
+
+	c := NewConfig()
+	c.Volumes["aliased"] = Options{optFsid: "fs-99999999", optOwner: "team-a"}
+	c.Policy = &Policy{Adoptable: []string{
+		"fs-11111111", "fs-22222222", "fs-33333333", "fs-44444444", "fs-55555555",
+		"fs-66666666", "fs-77777777", "fs-88888888", "fs-99999999", "fs-00000000",
+	}}
+
+	tests := []struct {
+		name string
+		id   string
+		tag  bool
+		err  bool
+	}{
+		{name: "terraform", id: "fs-11111111", tag: true},
+		{name: "console", id: "fs-22222222", tag: true},
+		{name: "created by the plugin", id: "fs-33333333", err: true},
+		{name: "created by the plugin in another namespace", id: "fs-44444444", err: true},
+		{name: "token of another namespace", id: "fs-55555555", err: true},
+		{name: "tagged with volume options", id: "fs-66666666", err: true},
+		{name: "already adopted", id: "fs-77777777"},
+		{name: "adopted by another volume", id: "fs-88888888", err: true},
+		{name: "aliased to another volume", id: "fs-99999999", err: true},
+		{name: "missing", id: "fs-00000000", err: true},
+		{name: "not in adopt_fsids", id: "fs-12121212", err: true},
+	}
+	for _, tt := range tests {
+		tags, err := AdoptionTags(e, c, tt.id, "foo", Options{optFsid: tt.id})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.err)
+		}
+		if (len(tags) == 1) != tt.tag {
+			t.Errorf("%s: got tags %v, want the %s tag %t", tt.name, tags, tagVolume, tt.tag)
+		}
+	}
+
+	// A volume bound in the configuration is only adopted with its filesystem.
+	if tags, err := AdoptionTags(e, c, "fs-99999999", "aliased", Options{optFsid: "fs-99999999"}); err != nil || tags != nil {
+		t.Errorf("aliased: got %v, %v, want nothing", tags, err)
+	}
+	if _, err := AdoptionTags(e, c, "fs-11111111", "aliased", Options{optFsid: "fs-11111111"}); err == nil {
+		t.Error("aliased: got no error for another filesystem")
+	}
+}
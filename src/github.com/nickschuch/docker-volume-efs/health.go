@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+)
+
+const (
+	healthHealthy  = "healthy"
+	healthDegraded = "degraded"
+	healthStale    = "stale"
+)
+
+var (
+	cliHealthInterval = kingpin.Flag("health-interval", "How often to check each mounted volume.").Default("30s").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_HEALTH_INTERVAL").Duration()
+	cliHealthTimeout  = kingpin.Flag("health-timeout", "How long a statfs on a mounted volume may take before it is marked stale.").Default("5s").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_HEALTH_TIMEOUT").Duration()
+)
+
+// VolumeHealth is the result of the last check of a mounted volume.
+type VolumeHealth struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	State   string    `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Latency string    `json:"latency"`
+	Checked time.Time `json:"checked"`
+}
+
+// HealthChecker regularly runs a timed statfs against every mounted volume.
+// A hung NFS server makes statfs block forever, so each check runs in its own
+// goroutine and a check which is still in flight is never started again.
+type HealthChecker struct {
+	Root     string
+	Interval time.Duration
+	Timeout  time.Duration
+
+	mu       sync.Mutex
+	volumes  map[string]*VolumeHealth
+	inflight map[string]bool
+}
+
+// Helper function to create a health checker for the volumes under a directory.
+func NewHealthChecker(root string, interval, timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		Root:     root,
+		Interval: interval,
+		Timeout:  timeout,
+		volumes:  make(map[string]*VolumeHealth),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Start checks all volumes every interval. This does not return.
+func (c *HealthChecker) Start() {
+	for {
+		c.CheckAll()
+		time.Sleep(c.Interval)
+	}
+}
+
+// CheckAll checks every volume which is currently mounted.
+func (c *HealthChecker) CheckAll() {
+	volumes, err := MountedVolumes(c.Root)
+	if err != nil {
+		log.WithField("error", err).Error("Cannot read the mount table")
+		return
+	}
+
+	for n := range volumes {
+		c.Check(n)
+	}
+
+	// Forget about volumes which are no longer mounted.
+	c.mu.Lock()
+	for n := range c.volumes {
+		if _, ok := volumes[n]; !ok && !c.inflight[n] {
+			delete(c.volumes, n)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Check runs a statfs with a deadline against a single volume and records the
+// result.
+func (c *HealthChecker) Check(name string) *VolumeHealth {
+	p := filepath.Join(c.Root, name)
+
+	c.mu.Lock()
+	if c.inflight[name] {
+		// The previous check never returned, the mount is hung.
+		h := c.record(name, p, healthStale, "statfs still blocked from a previous check", 0)
+		c.mu.Unlock()
+		return h
+	}
+	c.inflight[name] = true
+	c.mu.Unlock()
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(p, &st)
+
+		c.mu.Lock()
+		delete(c.inflight, name)
+		c.mu.Unlock()
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		latency := time.Since(start)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		switch {
+		case err == syscall.ESTALE:
+			return c.record(name, p, healthStale, err.Error(), latency)
+		case err != nil:
+			return c.record(name, p, healthDegraded, err.Error(), latency)
+		case latency > c.Timeout/2:
+			return c.record(name, p, healthDegraded, "statfs is slow", latency)
+		}
+		return c.record(name, p, healthHealthy, "", latency)
+
+	case <-time.After(c.Timeout):
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.record(name, p, healthStale, "statfs timed out", c.Timeout)
+	}
+}
+
+// Must be called with the lock held.
+func (c *HealthChecker) record(name, path, state, msg string, latency time.Duration) *VolumeHealth {
+	h := &VolumeHealth{
+		Name:    name,
+		Path:    path,
+		State:   state,
+		Error:   msg,
+		Latency: latency.String(),
+		Checked: time.Now(),
+	}
+
+	if prev, ok := c.volumes[name]; !ok || prev.State != state {
+		l := log.WithFields(log.Fields{
+			"volume": name,
+			"state":  state,
+		})
+		if state == healthHealthy {
+			l.Info("Volume health changed")
+		} else {
+			l.WithField("error", msg).Warn("Volume health changed")
+		}
+	}
+
+	c.volumes[name] = h
+	return h
+}
+
+// Get returns the last known health of a volume.
+func (c *HealthChecker) Get(name string) (*VolumeHealth, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.volumes[name]
+	return h, ok
+}
+
+// List returns the last known health of every mounted volume.
+func (c *HealthChecker) List() []*VolumeHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var list []*VolumeHealth
+	for _, h := range c.volumes {
+		list = append(list, h)
+	}
+	sort.Sort(byName(list))
+	return list
+}
+
+// Healthy reports false when any volume is stale.
+func (c *HealthChecker) Healthy() bool {
+	for _, h := range c.List() {
+		if h.State == healthStale {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the health of a volume in the form used by the volume Status.
+func (c *HealthChecker) Status(name string) map[string]interface{} {
+	h, ok := c.Get(name)
+	if !ok {
+		return nil
+	}
+
+	status := map[string]interface{}{
+		"health":        h.State,
+		"healthChecked": h.Checked.Format(time.RFC3339),
+		"healthLatency": h.Latency,
+	}
+	if h.Error != "" {
+		status["healthError"] = h.Error
+	}
+	return status
+}
+
+// ServeHealthz reports whether all mounted volumes are responding.
+func (c *HealthChecker) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	if !c.Healthy() {
+		http.Error(w, "stale volumes", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// ServeVolumes reports the health of each mounted volume.
+func (c *HealthChecker) ServeVolumes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.List())
+}
+
+type byName []*VolumeHealth
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
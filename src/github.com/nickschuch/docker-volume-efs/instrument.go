@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// InstrumentedDriver wraps a VolumeDriver and records metrics for every call.
+type InstrumentedDriver struct {
+	Driver Driver
+	Root   string
+
+	mu   sync.Mutex
+	refs map[string]int
+
+	// Calls which haven't returned yet, for the watchdog.
+	next     uint64
+	inflight map[uint64]inflightCall
+}
+
+type inflightCall struct {
+	method string
+	volume string
+	start  time.Time
+}
+
+// Helper function to wrap a driver with metrics.
+func NewInstrumentedDriver(d Driver, root string) *InstrumentedDriver {
+	return &InstrumentedDriver{
+		Driver:   d,
+		Root:     root,
+		refs:     make(map[string]int),
+		inflight: make(map[uint64]inflightCall),
+	}
+}
+
+func (i *InstrumentedDriver) Create(r Request) Response {
+	return i.observe("create", r.Name, func() Response { return i.Driver.Create(r) })
+}
+
+func (i *InstrumentedDriver) Remove(r Request) Response {
+	return i.observe("remove", r.Name, func() Response { return i.Driver.Remove(r) })
+}
+
+func (i *InstrumentedDriver) Path(r Request) Response {
+	return i.observe("path", r.Name, func() Response { return i.Driver.Path(r) })
+}
+
+func (i *InstrumentedDriver) Mount(r Request) Response {
+	res := i.observe("mount", r.Name, func() Response { return i.Driver.Mount(r) })
+	if res.Err == "" {
+		i.reference(r.Name, 1)
+	}
+	metricMounted.Set(float64(CountMounts(i.Root)))
+	return res
+}
+
+func (i *InstrumentedDriver) Unmount(r Request) Response {
+	res := i.observe("unmount", r.Name, func() Response { return i.Driver.Unmount(r) })
+	if res.Err == "" {
+		i.reference(r.Name, -1)
+	}
+	return res
+}
+
+func (i *InstrumentedDriver) Get(r Request) Response {
+	return i.observe("get", r.Name, func() Response { return i.Driver.Get(r) })
+}
+
+func (i *InstrumentedDriver) List(r Request) Response {
+	return i.observe("list", r.Name, func() Response { return i.Driver.List(r) })
+}
+
+func (i *InstrumentedDriver) Capabilities(r Request) Response {
+	return i.observe("capabilities", r.Name, func() Response { return i.Driver.Capabilities(r) })
+}
+
+func (i *InstrumentedDriver) observe(method, volume string, call func() Response) Response {
+	start := time.Now()
+
+	// Creating a volume waits for AWS (and clones copy data), so it is
+	// expected to take a while and isn't watched.
+	if method != "create" {
+		id := i.track(method, volume, start)
+		defer i.untrack(id)
+	}
+
+	res := call()
+
+	var err error
+	if res.Err != "" {
+		err = errors.New(res.Err)
+	}
+	metricDriverCalls.Inc(method, Result(err))
+	metricDriverDuration.Since(start, method, Result(err))
+	return res
+}
+
+// Helper function to record a call which hasn't returned yet.
+func (i *InstrumentedDriver) track(method, volume string, start time.Time) uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.next++
+	i.inflight[i.next] = inflightCall{method: method, volume: volume, start: start}
+	return i.next
+}
+
+// Helper function to forget a call once it has returned.
+func (i *InstrumentedDriver) untrack(id uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.inflight, id)
+}
+
+// Oldest returns the method of the call which has been running the longest,
+// and how long it has been running for. Calls for a volume which is being
+// provisioned (eg. a Mount waiting for AWS to create its mount target) aren't
+// counted, and only run from when it was provisioned.
+func (i *InstrumentedDriver) Oldest() (string, time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	method, age := "", time.Duration(0)
+	for _, c := range i.inflight {
+		start := c.start
+		if c.volume != "" {
+			provisioning, end := Provisioned(c.volume)
+			if provisioning {
+				continue
+			}
+			if end.After(start) {
+				start = end
+			}
+		}
+		if d := time.Since(start); d > age {
+			method, age = c.method, d
+		}
+	}
+	return method, age
+}
+
+// Helper function to track the number of active references to each volume.
+func (i *InstrumentedDriver) reference(name string, delta int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.refs[name] += delta
+	if i.refs[name] <= 0 {
+		delete(i.refs, name)
+	}
+
+	total := 0
+	for _, n := range i.refs {
+		total += n
+	}
+	metricReferences.Set(float64(total))
+}
+
+// Helper function to count the volumes currently mounted under a directory.
+func CountMounts(d string) int {
+	volumes, _ := MountedVolumes(d)
+	return len(volumes)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// stepDriver is a driver whose Mount runs the steps it is sent, until the
+// channel is closed.
+type stepDriver struct {
+	Driver
+	steps chan func()
+}
+
+func (d stepDriver) Mount(r Request) Response {
+	for step := range d.steps {
+		step()
+	}
+	return Response{Err: "done"}
+}
+
+func TestOldest(t *testing.T) {
+	d := stepDriver{steps: make(chan func())}
+	i := NewInstrumentedDriver(d, t.TempDir())
+
+	returned := make(chan bool)
+	go func() {
+		i.Mount(Request{Name: "foo"})
+		close(returned)
+	}()
+
+	// Helper function to run a step of the Mount and wait for it.
+	run := func(step func()) {
+		ran := make(chan bool)
+		d.steps <- func() {
+			step()
+			close(ran)
+		}
+		<-ran
+	}
+
+	// Provisioning isn't counted.
+	var done func()
+	run(func() { done = TrackProvisioning("foo") })
+	time.Sleep(20 * time.Millisecond)
+	if method, age := i.Oldest(); method != "" || age != 0 {
+		t.Errorf("while provisioning: got %s running for %s, want nothing", method, age)
+	}
+
+	// The call only runs from when it was provisioned.
+	run(done)
+	if method, age := i.Oldest(); method != "mount" || age >= 20*time.Millisecond {
+		t.Errorf("once provisioned: got %q running for %s, want mount for less than 20ms", method, age)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, age := i.Oldest(); age < 20*time.Millisecond {
+		t.Errorf("got %s, want at least 20ms", age)
+	}
+
+	close(d.steps)
+	<-returned
+	if method, age := i.Oldest(); method != "" || age != 0 {
+		t.Errorf("once returned: got %s running for %s, want nothing", method, age)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/alecthomas/kingpin"
+)
+
+// Docker finds plugins by their unix socket in /run/docker/plugins, or by a
+// spec file in /etc/docker/plugins with the address of a plugin it can't see
+// the socket of (eg. one on another host or in another container). Plugins
+// served over TCP must use TLS: the plugin API mounts filesystems as root, so
+// only clients with a certificate signed by our CA are accepted.
+
+var (
+	cliListen        = kingpin.Flag("listen", "Address to serve the plugin API on (unix:///path or tcp://host:port).").Default("unix://" + socketAddress).OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_LISTEN").String()
+	cliTLSCert       = kingpin.Flag("tls-cert", "Certificate of the plugin, required for tcp://.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_TLS_CERT").String()
+	cliTLSKey        = kingpin.Flag("tls-key", "Key of the plugin certificate.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_TLS_KEY").String()
+	cliTLSCA         = kingpin.Flag("tls-ca", "CA which signs the certificates of clients (and of the plugin, for the spec file).").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_TLS_CA").String()
+	cliTLSClientCert = kingpin.Flag("tls-client-cert", "Client certificate Docker presents to the plugin, written to the spec file.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_TLS_CLIENT_CERT").String()
+	cliTLSClientKey  = kingpin.Flag("tls-client-key", "Key of the client certificate, written to the spec file.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_TLS_CLIENT_KEY").String()
+	cliSpecDir       = kingpin.Flag("spec-dir", "Directory to write the plugin spec file to when listening on tcp:// (empty to not write one).").Default("/etc/docker/plugins").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SPEC_DIR").String()
+	cliSpecAddr      = kingpin.Flag("spec-addr", "Address (host:port) Docker reaches the plugin on, when it isn't the listen address.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SPEC_ADDR").String()
+)
+
+// PluginSpec is a plugin spec file (efs.json) for Docker.
+type PluginSpec struct {
+	Name      string
+	Addr      string
+	TLSConfig *PluginSpecTLS `json:",omitempty"`
+}
+
+// PluginSpecTLS is the TLS configuration Docker connects to a plugin with.
+type PluginSpecTLS struct {
+	InsecureSkipVerify bool
+	CAFile             string `json:",omitempty"`
+	CertFile           string `json:",omitempty"`
+	KeyFile            string `json:",omitempty"`
+}
+
+// Helper function to listen on the plugin API address. Returns the listener
+// and the file to remove on shutdown (the socket or the spec file).
+func Listen(addr string) (net.Listener, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid --listen: %s", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, "", fmt.Errorf("invalid --listen: %s (unix:///path)", addr)
+		}
+		l, err := NewUnixSocket("root", u.Path)
+		return l, u.Path, err
+	case "tcp":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid --listen: %s (tcp://host:port)", addr)
+		}
+		return NewTLSSocket(u.Host)
+	default:
+		return nil, "", fmt.Errorf("invalid --listen: %s (unix:///path or tcp://host:port)", addr)
+	}
+}
+
+// Helper function to load the TLS configuration of the plugin, which requires
+// clients to present a certificate signed by the CA.
+func ServerTLSConfig(cert, key, ca string) (*tls.Config, error) {
+	if cert == "" || key == "" || ca == "" {
+		return nil, fmt.Errorf("--tls-cert, --tls-key and --tls-ca are required to listen on tcp://")
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", ca)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Helper function to listen for TLS connections and write the spec file Docker
+// finds the plugin by.
+func NewTLSSocket(addr string) (net.Listener, string, error) {
+	c, err := ServerTLSConfig(*cliTLSCert, *cliTLSKey, *cliTLSCA)
+	if err != nil {
+		return nil, "", err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if *cliSpecDir == "" {
+		return tls.NewListener(l, c), "", nil
+	}
+
+	spec := PluginSpec{
+		Name: pluginId,
+		Addr: "tcp://" + SpecAddr(*cliSpecAddr, l.Addr()),
+		TLSConfig: &PluginSpecTLS{
+			CAFile:   *cliTLSCA,
+			CertFile: *cliTLSClientCert,
+			KeyFile:  *cliTLSClientKey,
+		},
+	}
+	p := filepath.Join(*cliSpecDir, pluginId+".json")
+	if err := WriteSpec(p, spec); err != nil {
+		l.Close()
+		return nil, "", err
+	}
+
+	return tls.NewListener(l, c), p, nil
+}
+
+// Helper function to get the address Docker reaches the plugin on. A listener
+// on all addresses is reached on localhost unless told otherwise.
+func SpecAddr(addr string, l net.Addr) string {
+	if addr != "" {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(l.String())
+	if err != nil {
+		return l.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Helper function to write a plugin spec file.
+func WriteSpec(p string, spec PluginSpec) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(b, '\n'), 0644)
+}
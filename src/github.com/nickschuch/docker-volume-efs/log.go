@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+)
+
+var (
+	cliLogLevel  = kingpin.Flag("log-level", "Logging level (debug, info, warn, error).").Default("info").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_LOG_LEVEL").String()
+	cliLogFormat = kingpin.Flag("log-format", "Logging format (text, json).").Default("text").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_LOG_FORMAT").Enum("text", "json")
+)
+
+// Helper function to configure the global logger from the CLI arguments.
+func SetupLogging() error {
+	level, err := log.ParseLevel(*cliLogLevel)
+	if err != nil {
+		return err
+	}
+
+	// Verbose is kept for backwards compatibility and is the same as debug.
+	if *cliVerbose {
+		level = log.DebugLevel
+	}
+	log.SetLevel(level)
+
+	switch *cliLogFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.TextFormatter{})
+	}
+
+	log.SetOutput(os.Stderr)
+	return nil
+}
+
+// Helper function to get a logger for a single plugin API call. Every entry
+// logged during the call carries the same request ID.
+func RequestLogger(call, name string) *log.Entry {
+	return log.WithFields(log.Fields{
+		"request_id": NewRequestID(),
+		"call":       call,
+		"volume":     name,
+	})
+}
+
+// Helper function to generate a short random ID for correlating log entries.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", os.Getpid())
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/alecthomas/kingpin"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/calavera/docker-volume-api"
 	"github.com/docker/docker/pkg/mount"
-	"github.com/jasonlvhit/gocron"
 )
 
 const (
@@ -26,120 +25,821 @@ var (
 	defaultDir    = filepath.Join(dkvolume.DefaultDockerRootDirectory, pluginId)
 
 	// CLI Arguments.
-	cliRoot     = kingpin.Flag("root", "EFS volumes root directory.").Default(defaultDir).String()
-	cliSecurity = kingpin.Flag("security", "Security group to be assigned to new EFS Mount points.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SECURITY").String()
-	cliVerbose  = kingpin.Flag("verbose", "Show verbose logging.").Bool()
+	cliRoot     = kingpin.Flag("root", "EFS volumes root directory.").Default(defaultDir).OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_ROOT").String()
+	cliSecurity = kingpin.Flag("security", "Comma separated security groups to be assigned to new EFS Mount points.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SECURITY").String()
+	cliVerbose  = kingpin.Flag("verbose", "Show verbose logging (same as --log-level=debug).").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_VERBOSE").Bool()
 )
 
 type DriverEFS struct {
 	Root   string
 	Region string
+	Zone   string
 	Subnet string
+	Health *HealthChecker
+	Quota  *QuotaChecker
+	Cache  *MountCache
 }
 
-func (d DriverEFS) Create(r dkvolume.Request) dkvolume.Response {
-	log.Printf("Create: %s", r.Name)
-	return dkvolume.Response{}
+func (d DriverEFS) Create(r Request) Response {
+	l := RequestLogger("create", r.Name)
+	cfg := CurrentConfig()
+
+	if err := cfg.Policy.Check(r.Name); err != nil {
+		l.WithField("error", err).Error("Volume not allowed")
+		return Response{Err: err.Error()}
+	}
+	for _, k := range globalOptions {
+		if _, ok := r.Options[k]; ok {
+			err := fmt.Errorf("%s can only be set globally", k)
+			l.WithField("error", err).Error("Volume not allowed")
+			return Response{Err: err.Error()}
+		}
+	}
+	for _, k := range credentialOptions {
+		if _, ok := r.Options[k]; ok {
+			err := fmt.Errorf("%s can only be set in the configuration (eg. a profile)", k)
+			l.WithField("error", err).Error("Volume not allowed")
+			return Response{Err: err.Error()}
+		}
+	}
+	if err := UnknownOptions(r.Options); err != nil {
+		l.WithField("error", err).Error("Volume not allowed")
+		return Response{Err: err.Error()}
+	}
+
+	// Options are only given when the volume is created, so this is where the
+	// EFS Filesystem is provisioned.
+	o, err := cfg.VolumeOptions(r.Name, r.Options)
+	if err != nil {
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
+	}
+	if id := o.Get(optFsid, ""); id != "" {
+		if err := ValidateFsid(id); err != nil {
+			l.WithField("error", err).Error("Cannot resolve volume options")
+			return Response{Err: err.Error()}
+		}
+	}
+	if _, err := DNSMode(o); err != nil {
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
+	}
+	if _, err := BackupPolicyStatus(o); err != nil {
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
+	}
+	if _, _, err := Quota(o); err != nil {
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
+	}
+	if source, _, err := CloneSource(o); err != nil || source != "" {
+		if err == nil {
+			_, err = CloneInBackground(o)
+		}
+		if err == nil && source == r.Name {
+			err = fmt.Errorf("volume %s can't be cloned from itself", r.Name)
+		}
+		if err == nil {
+			err = cfg.Policy.Check(source)
+		}
+		if err == nil {
+			var so Options
+			so, err = d.SourceOptions(l, source)
+			if err == nil {
+				err = AuthorizeClone(cfg.Global.Get(optOwnerLabel, ""), source, so, o)
+			}
+		}
+		if err != nil {
+			l.WithField("error", err).Error("Cannot resolve volume options")
+			return Response{Err: err.Error()}
+		}
+	}
+	if p := o.Get(optProfile, ""); p != "" {
+		l = l.WithField("profile", p)
+	}
+
+	// Mount only searches the regions in the configuration for volumes, so
+	// another region can't be given when creating one.
+	if region := o.Get(optRegion, ""); region != "" && region != d.Region && !Contains(cfg.Regions(), region) {
+		err := fmt.Errorf("region %s is not used by the configuration (eg. a profile)", region)
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
+	}
+
+	e := d.EFS(o, l)
+
+	// The explicit options (including the profile) are recorded as tags when
+	// the EFS Filesystem is provisioned, so they also apply when the volume is
+	// mounted. Creating a volume which already exists can't change them (eg.
+	// take over its owner), and an external filesystem adopted with -o fsid is
+	// only tagged with the volume name so other hosts can find it.
+	explicit := Options(r.Options).Merge()
+	var tags []*efs.Tag
+	provision := false
+	if id := o.Get(optFsid, ""); id != "" {
+		tags, err = AdoptionTags(e, cfg, id, r.Name, explicit)
+	} else {
+		provision, err = Provisioning(e, cfg.Policy, r.Name, explicit)
+		if provision {
+			tags = append(OptionTags(explicit), CloningTags(explicit)...)
+		}
+	}
+	if err != nil {
+		l.WithField("error", err).Error("Cannot create volume")
+		return Response{Err: err.Error()}
+	}
+
+	m, err := GetEFS(l, e, cfg.Policy, d.Subnets(o), o, r.Name)
+	if err != nil {
+		l.WithField("error", err).Error("Cannot get EFS Filesystem")
+		return Response{Err: err.Error()}
+	}
+
+	if err := TagFilesystem(e, *m.FileSystemId, tags); err != nil {
+		l.WithField("error", err).Error("Cannot tag EFS Filesystem")
+		return Response{Err: err.Error()}
+	}
+
+	if o.Get(optFsid, "") != "" {
+		RecordAdoption(e, r.Name, *m.FileSystemId)
+		l.WithField("filesystem_id", *m.FileSystemId).Info("Adopted")
+		return Response{}
+	}
+	if !provision {
+		l.WithField("filesystem_id", *m.FileSystemId).Debug("Already exists")
+		return Response{}
+	}
+
+	if err := EnrollBackup(e, *m.FileSystemId, o); err != nil {
+		l.WithField("error", err).Error("Cannot set the backup policy")
+		return Response{Err: err.Error()}
+	}
+	l.WithField("filesystem_id", *m.FileSystemId).Info("Created")
+
+	// Copy the data of the volume it is cloned from, if any.
+	if err := d.Clone(l, e, *m.FileSystemId, r.Name, explicit); err != nil {
+		return Response{Err: err.Error()}
+	}
+	return Response{}
+}
+
+// Helper function to determine if creating a volume provisions its EFS
+// Filesystem. A volume which already exists can only be created again with
+// the options it was created with.
+func Provisioning(e *efs.EFS, p *Policy, n string, explicit Options) (bool, error) {
+	fs, err := DescribeFilesystem(e, p.Token(n))
+	if err != nil {
+		return false, err
+	}
+	if len(fs.FileSystems) == 0 {
+		return true, nil
+	}
+
+	tags, err := DescribeTags(e, *fs.FileSystems[0].FileSystemId)
+	if err != nil {
+		return false, err
+	}
+	if !p.Managed(fs.FileSystems[0], tags) {
+		return false, p.NotManaged(*fs.FileSystems[0].FileSystemId, n)
+	}
+	return false, ConflictingOptions(n, TagOptions(tags), explicit)
 }
 
-func (d DriverEFS) Remove(r dkvolume.Request) dkvolume.Response {
-	log.Printf("Remove: %s", r.Name)
-	return dkvolume.Response{}
+func (d DriverEFS) Remove(r Request) Response {
+	l := RequestLogger("remove", r.Name)
+	l.Info("Remove")
+	return Response{}
 }
 
-func (d DriverEFS) Path(r dkvolume.Request) dkvolume.Response {
-	log.Printf("Path: %s", filepath.Join(d.Root, r.Name))
-	return dkvolume.Response{Mountpoint: filepath.Join(d.Root, r.Name)}
+func (d DriverEFS) Path(r Request) Response {
+	l := RequestLogger("path", r.Name)
+	if err := ValidateName(r.Name); err != nil {
+		l.WithField("error", err).Error("Invalid volume name")
+		return Response{Err: err.Error()}
+	}
+
+	p := filepath.Join(d.Root, r.Name)
+	l.WithField("path", p).Debug("Path")
+	return Response{Mountpoint: p}
 }
 
-func (d DriverEFS) Mount(r dkvolume.Request) dkvolume.Response {
+func (d DriverEFS) Mount(r Request) Response {
+	l := RequestLogger("mount", r.Name)
 	p := filepath.Join(d.Root, r.Name)
+	start := time.Now()
+	cfg := CurrentConfig()
+
+	if err := cfg.Policy.Check(r.Name); err != nil {
+		l.WithField("error", err).Error("Volume not allowed")
+		return Response{Err: err.Error()}
+	}
 
-	// Check if the directory already exists.
-	nfs, err := mount.Mounted(p)
+	// Check if the directory is already mounted. We use the mount table because
+	// a stat on a dead NFS mount would block.
+	mounts, err := MountedVolumes(d.Root)
 	if err != nil {
-		return dkvolume.Response{Err: err.Error()}
+		l.WithField("error", err).Error("Cannot determine if mounted")
+		return Response{Err: err.Error()}
 	}
-	if Exists(p) && nfs {
-		log.Printf("Existing: %s", r.Name)
-		return dkvolume.Response{Mountpoint: p}
+
+	// An existing mount is compared with the mount target below, which might
+	// have changed (eg. been recreated with another IP) since it was cached.
+	info, mounted := mounts[r.Name]
+	o, m, err := d.MountableTarget(l, r.Name, mounted)
+	if err != nil {
+		l.WithField("error", err).Error("Cannot get EFS Filesystem")
+		return Response{Err: err.Error()}
 	}
+	l = l.WithFields(log.Fields{
+		"filesystem_id": *m.FileSystemId,
+		"mount_target":  *m.IpAddress,
+	})
 
-	e := efs.New(&aws.Config{Region: aws.String(d.Region)})
+	if err := Authorize(cfg.Global.Get(optOwnerLabel, ""), o, r.Name); err != nil {
+		l.WithField("error", err).Error("Mount not authorized")
+		return Response{Err: err.Error()}
+	}
 
-	m, err := GetEFS(e, d.Subnet, r.Name)
+	// Volumes over their quota may not be mounted (or only read-only). Their
+	// usage is only known from the last check, mounting doesn't wait for EFS.
+	var usage *VolumeUsage
+	if limit, _, _ := Quota(o); limit > 0 && d.Quota != nil {
+		usage, err = d.Quota.Cached(r.Name, o)
+		if err != nil {
+			l.WithField("error", err).Warn("Cannot check usage")
+		} else if usage != nil && usage.Exceeded && usage.Action == quotaRefuse {
+			err := fmt.Errorf("volume %s is over its quota (%d of %d bytes)", r.Name, usage.Bytes, usage.Quota)
+			l.WithField("error", err).Error("Cannot mount")
+			return Response{Err: err.Error()}
+		}
+	}
+
+	if mounted {
+		// The mount helper mounts through a local proxy (TLS) or a DNS name, so
+		// the mount source can only be compared for plain NFS mounts by IP.
+		target := *m.IpAddress
+		if mode, _ := DNSMode(o); UsesMountHelper(o) || mode != "" {
+			target = ""
+		}
+
+		reason := StaleReason(d.Health, r.Name, info, target)
+		if reason == "" {
+			l.WithField("path", p).Info("Using existing mount")
+			return Response{Mountpoint: p}
+		}
+
+		// The existing mount is dead, detach it and mount again below.
+		l.WithFields(log.Fields{
+			"reason": reason,
+			"source": MountSource(info),
+		}).Warn("Recovering stale mount")
+		if err := DetachMount(l, p); err != nil {
+			metricRecoveries.Inc(reason, Result(err))
+			return Response{Err: err.Error()}
+		}
+		metricRecoveries.Inc(reason, Result(nil))
+		d.Cache.Invalidate(r.Name)
+	}
+
+	// Fail early if the mount target is in a network this host can't reach.
+	if err := CheckRoute(m, *cliRouteTimeout); err != nil {
+		l.WithField("error", err).Error("Cannot reach mount target")
+		d.Cache.Invalidate(r.Name)
+		return Response{Err: err.Error()}
+	}
+
+	addr, zone, err := MountAddress(l, m, d.region(o), d.zone(o), o)
 	if err != nil {
-		return dkvolume.Response{Err: err.Error()}
+		l.WithField("error", err).Error("Cannot resolve volume options")
+		return Response{Err: err.Error()}
 	}
 
 	if err := os.MkdirAll(p, 0755); err != nil {
-		return dkvolume.Response{Err: err.Error()}
+		l.WithField("error", err).Error("Cannot create mount directory")
+		return Response{Err: err.Error()}
 	}
 
 	// Mount the EFS volume to the local filesystem.
 	// @todo, Swap this out with an NFS client library.
-	if err := Exec("mount", "-t", "nfs4", m+":/", p); err != nil {
-		return dkvolume.Response{Err: err.Error()}
+	if err := Exec("mount", MountArgs(m, addr, zone, p, o)...); err != nil {
+		l.WithField("error", err).Error("Mount failed")
+		d.Cache.Invalidate(r.Name)
+		return Response{Err: err.Error()}
+	}
+	if usage != nil {
+		d.Quota.Enforce(l, r.Name, usage.readOnly, usage)
 	}
 
-	log.Printf("Mounting: %s", r.Name)
-	return dkvolume.Response{Mountpoint: p}
+	l.WithFields(log.Fields{
+		"path":     p,
+		"address":  addr,
+		"duration": time.Since(start).String(),
+	}).Info("Mounted")
+	return Response{Mountpoint: p}
 }
 
-func (d DriverEFS) Unmount(r dkvolume.Request) dkvolume.Response {
+func (d DriverEFS) Unmount(r Request) Response {
+	l := RequestLogger("unmount", r.Name)
+	l.Debug("Unmount deferred to the cleanup task")
+
 	// We defer unmounting to the cleanup task.
-	return dkvolume.Response{}
+	return Response{}
+}
+
+// EFS Filesystems are shared by every host (and Remove never deletes them),
+// so volumes have a global scope.
+func (d DriverEFS) Capabilities(r Request) Response {
+	return Response{Capabilities: &Capabilities{Scope: "global"}}
+}
+
+func (d DriverEFS) Get(r Request) Response {
+	l := RequestLogger("get", r.Name)
+	if err := ValidateName(r.Name); err != nil {
+		l.WithField("error", err).Error("Invalid volume name")
+		return Response{Err: err.Error()}
+	}
+
+	l.Debug("Get")
+	return Response{Volume: d.volume(l, r.Name)}
+}
+
+func (d DriverEFS) List(r Request) Response {
+	l := RequestLogger("list", r.Name)
+
+	mounts, err := MountedVolumes(d.Root)
+	if err != nil {
+		l.WithField("error", err).Error("Cannot read the mount table")
+		return Response{Err: err.Error()}
+	}
+
+	names := make(map[string]bool)
+	for n := range mounts {
+		names[n] = true
+	}
+
+	// Include the volumes which aren't mounted on this host.
+	for n := range d.KnownVolumes(l) {
+		names[n] = true
+	}
+
+	var volumes []*Volume
+	for n := range names {
+		volumes = append(volumes, d.volume(l, n))
+	}
+
+	l.WithField("count", len(volumes)).Debug("List")
+	return Response{Volumes: volumes}
+}
+
+// Helper function to get the subnets to create mount targets in for a volume,
+// starting with the subnet to mount from. Defaults to the subnet of this host
+// for volumes in the same region.
+func (d DriverEFS) Subnets(o Options) []string {
+	subnets := o.List(optSubnets)
+	if s := o.Get(optSubnet, ""); s != "" && !Contains(subnets, s) {
+		subnets = append([]string{s}, subnets...)
+	}
+	if len(subnets) == 0 && d.region(o) == d.Region {
+		subnets = []string{d.Subnet}
+	}
+	return subnets
+}
+
+// Helper function to get the region of a volume, defaulting to the region of
+// this host.
+func (d DriverEFS) region(o Options) string {
+	return o.Get(optRegion, d.Region)
+}
+
+// Helper function to get the availability zone of this host, if a volume is
+// in the same region.
+func (d DriverEFS) zone(o Options) string {
+	if d.region(o) != d.Region {
+		return ""
+	}
+	return d.Zone
+}
+
+// Helper function to get the options and mount target of a volume, creating
+// the EFS Filesystem if it doesn't exist. These are cached, so mounting a
+// volume again doesn't call the EFS API.
+func (d DriverEFS) MountTarget(l *log.Entry, n string) (Options, *efs.MountTargetDescription, error) {
+	if o, m, ok := d.Cache.Get(n, d.Zone); ok {
+		l.Debug("Using cached mount target")
+		return o, m, nil
+	}
+
+	e, _, o, err := d.Lookup(l, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := GetEFS(l, e, CurrentConfig().Policy, d.Subnets(o), o, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.Cache.Put(n, d.Zone, o, m)
+	return o, m, nil
+}
+
+// Helper function to get the mount target to mount a volume from, once it
+// has finished cloning. Cached options might be from before the clone
+// finished (eg. on another host), so they are checked again without the
+// cache. Fresh bypasses the cache altogether.
+func (d DriverEFS) MountableTarget(l *log.Entry, n string, fresh bool) (Options, *efs.MountTargetDescription, error) {
+	if fresh {
+		d.Cache.Invalidate(n)
+	}
+	o, m, err := d.MountTarget(l, n)
+	if err != nil || o.Get(optCloning, "") == "" {
+		return o, m, err
+	}
+
+	d.Cache.Invalidate(n)
+	o, m, err = d.MountTarget(l, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	if source := o.Get(optCloning, ""); source != "" {
+		return nil, nil, fmt.Errorf("volume %s has not finished cloning from %s", n, source)
+	}
+	return o, m, nil
+}
+
+// Helper function to get the EFS client for the region and role of a volume.
+func (d DriverEFS) EFS(o Options, l *log.Entry) *efs.EFS {
+	return NewEFS(d.region(o), o, l)
+}
+
+// Helper function to get the names of the volumes in the configuration (with
+// an fsid) and of the EFS Filesystems managed by the plugin, in every region
+// and role the configuration uses. The filesystems are listed with their tags,
+// so the descriptions of their volumes are cached as well.
+func (d DriverEFS) KnownVolumes(l *log.Entry) map[string]bool {
+	cfg := CurrentConfig()
+	names := make(map[string]bool)
+
+	for n, o := range cfg.Volumes {
+		if o.Get(optFsid, "") != "" {
+			names[n] = true
+		}
+	}
+	for _, location := range cfg.Locations() {
+		filesystems, err := listFileSystems(d.EFS(location, l))
+		if err != nil {
+			l.WithField("error", err).Warn("Cannot list EFS Filesystems")
+		}
+		for _, fs := range filesystems {
+			n, ok := cfg.Policy.Name(fs.Description(), fs.Tags)
+			if !ok {
+				continue
+			}
+			names[n] = true
+
+			// Only where its options place it (not a namesake in another
+			// account or region).
+			tags := TagOptions(fs.Tags)
+			o, err := cfg.VolumeOptions(n, tags)
+			if err != nil || d.location(o) != d.location(location) {
+				continue
+			}
+			d.Cache.PutStatus(n, &VolumeStatus{
+				FileSystemId: *fs.FileSystemId,
+				Usage:        Usage(fs.Description()),
+				Options:      o,
+				Tags:         tags,
+			})
+		}
+	}
+	return names
+}
+
+// Helper function to find an existing volume and resolve its options,
+// including the options it was created with (recorded as tags on the EFS
+// Filesystem). A profile given at creation may use a different role (and so
+// account) or region to the configuration, so each location is tried in turn.
+// Returns the client for the account and region the volume lives in, and its
+// filesystem (nil if it doesn't exist yet).
+func (d DriverEFS) Lookup(l *log.Entry, n string) (*efs.EFS, *efs.FileSystemDescription, Options, error) {
+	cfg := CurrentConfig()
+
+	o, err := cfg.VolumeOptions(n, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	locations := []Options{o}
+	seen := map[string]bool{d.location(o): true}
+	for _, location := range cfg.Locations() {
+		if key := d.location(location); !seen[key] {
+			seen[key] = true
+			locations = append(locations, location)
+		}
+	}
+
+	for i, location := range locations {
+		e := d.EFS(location, l)
+
+		fs, tags, err := FindFilesystem(e, cfg.Policy, o.Get(optFsid, ""), n)
+		if err != nil && i == 0 {
+			return nil, nil, nil, err
+		}
+		if err != nil {
+			// Another profile's role might not be usable from this host.
+			l.WithFields(log.Fields{
+				"role_arn": location.Get(optRoleArn, ""),
+				"region":   d.region(location),
+				"error":    err,
+			}).Warn("Cannot describe EFS Filesystem")
+			continue
+		}
+		if fs == nil {
+			continue
+		}
+
+		explicit := TagOptions(tags)
+		if !cfg.Policy.Managed(fs, tags) {
+			explicit[optFsid] = *fs.FileSystemId
+		}
+		o, err := cfg.VolumeOptions(n, explicit)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return e, fs, o, nil
+	}
+
+	// The volume doesn't exist yet, it will be created with the configuration.
+	return d.EFS(o, l), nil, o, nil
+}
+
+// Helper function to identify the account and region of a set of options.
+func (d DriverEFS) location(o Options) string {
+	return strings.Join([]string{d.region(o), o.Get(optRoleArn, ""), o.Get(optExternalId, "")}, "|")
+}
+
+// Helper function to describe a volume for Get and List calls.
+func (d DriverEFS) volume(l *log.Entry, n string) *Volume {
+	v := &Volume{
+		Name:   n,
+		Status: make(map[string]interface{}),
+	}
+
+	p := filepath.Join(d.Root, n)
+	if nfs, err := mount.Mounted(p); err == nil && nfs {
+		v.Mountpoint = p
+	}
+	if d.Health != nil {
+		for k, s := range d.Health.Status(n) {
+			v.Status[k] = s
+		}
+	}
+	if c := GetClone(n); c != nil {
+		for k, s := range c.Status() {
+			v.Status[k] = s
+		}
+	}
+
+	// Show how the volume was provisioned (eg. the profile).
+	s, err := d.Describe(l, n)
+	if err != nil {
+		l.WithField("error", err).Warn("Cannot describe EFS Filesystem")
+		return v
+	}
+	if s == nil {
+		return v
+	}
+	v.Status["filesystemId"] = s.FileSystemId
+	v.Status["usageBytes"] = s.Usage
+	if limit, _, err := Quota(s.Options); err == nil && limit > 0 {
+		v.Status["quotaBytes"] = limit
+		v.Status["quotaExceeded"] = s.Usage > limit
+	}
+	if s.External {
+		v.Status["external"] = true
+	}
+	for k, o := range s.Tags {
+		v.Status[k] = o
+	}
+
+	return v
+}
+
+// Helper function to describe an existing volume. Descriptions are cached, so
+// Get and List only call the EFS API once for each volume every
+// --mount-cache-ttl. Returns nil if the volume doesn't exist.
+func (d DriverEFS) Describe(l *log.Entry, n string) (*VolumeStatus, error) {
+	if s, ok := d.Cache.Status(n); ok {
+		return s, nil
+	}
+
+	e, fs, o, err := d.Lookup(l, n)
+	if err != nil || fs == nil {
+		return nil, err
+	}
+
+	tags, err := DescribeTags(e, *fs.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+	s := &VolumeStatus{
+		FileSystemId: *fs.FileSystemId,
+		Usage:        Usage(fs),
+		External:     o.Get(optFsid, "") != "",
+		Options:      o,
+		Tags:         TagOptions(tags),
+	}
+
+	d.Cache.PutStatus(n, s)
+	return s, nil
 }
 
 func main() {
-	kingpin.Parse()
+	kingpin.CommandLine.Help = configHelp
+	command := kingpin.MustParse(kingpin.CommandLine.Parse(CommandArgs(os.Args[1:])))
+
+	if err := SetupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ReloadConfig(); err != nil {
+		log.Fatal(err)
+	}
+	cfg := CurrentConfig()
+
+	root, err := RootDir(*cliRoot, *cliPropagatedMount)
+	if err != nil {
+		log.Fatal(err)
+	}
+	*cliRoot = root
+
+	if command != cmdServe.FullCommand() {
+		if err := RunCommand(command); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	interval, err := cfg.CleanupInterval()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// This is a scheduled set of tasks which will unmount old directories which
 	// are not being used by container instances.
-	gocron.Every(15).Seconds().Do(Cleanup, *cliRoot)
-	go gocron.Start()
+	scheduler := NewScheduler()
+	scheduler.Every(uint64(interval.Seconds())).Seconds().Do(Cleanup, *cliRoot)
+	go scheduler.Start()
+
+	NotifyStatus("STATUS=Discovering the region and subnet of this host")
+	d, err := NewDriver(*cliRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Check the volumes which are still mounted (eg. from a previous run)
+	// before we are ready, then regularly check they are still responding.
+	NotifyStatus("STATUS=Checking mounted volumes")
+	hc := NewHealthChecker(*cliRoot, *cliHealthInterval, *cliHealthTimeout)
+	hc.CheckAll()
+	go hc.Start()
+	d.Health = hc
+
+	// Regularly check the usage of each mounted volume with a quota.
+	qc := NewQuotaChecker(d, *cliQuotaInterval)
+	go qc.Start()
+	d.Quota = qc
+
+	// Expose metrics and volume health for scraping and probes.
+	if *cliMetricsAddr != "" {
+		go func() {
+			log.WithField("addr", *cliMetricsAddr).Info("Serving metrics and health")
+			log.Fatal(ServeStatus(*cliMetricsAddr, hc))
+		}()
+	}
+
+	i := NewInstrumentedDriver(d, *cliRoot)
+	h := NewHandler(i)
+
+	// A socket passed by systemd is used instead of --listen, and systemd
+	// removes it.
+	l, err := SocketActivation()
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := ""
+	if l == nil {
+		l, file, err = Listen(*cliListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	addr := l.Addr().Network() + "://" + l.Addr().String()
+
+	log.WithFields(log.Fields{
+		"listen": addr,
+		"root":   *cliRoot,
+		"region": d.Region,
+		"subnet": d.Subnet,
+	}).Info("Listening")
+	reload := func() error {
+		d.Cache.Flush()
+		FlushAdoptions()
+		return Reload()
+	}
+
+	NotifyStatus(fmt.Sprintf("READY=1\nSTATUS=Serving on %s (%d volumes mounted)", addr, CountMounts(*cliRoot)))
+	if interval := WatchdogInterval(); interval > 0 {
+		go Watchdog(i, interval, *cliWatchdogStall)
+	}
+	if err := Serve(h, l, reload); err != nil {
+		log.WithField("error", err).Error("Server stopped")
+	}
+
+	// Ensure a cleanup task isn't running while we exit.
+	scheduler.Stop()
+
+	if *cliUnmountOnExit {
+		Cleanup(*cliRoot)
+	}
+
+	if file != "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"error": err,
+			}).Warn("Cannot remove socket or spec file")
+		}
+	}
+
+	log.Info("Stopped")
+}
+
+// Helper function to discover where this host is and create the driver.
+func NewDriver(root string) (DriverEFS, error) {
+	d := DriverEFS{
+		Root:  root,
+		Cache: NewMountCache(*cliMountCacheTTL),
+	}
 
 	// Discovery the region which this instance resides. This will ensure the
 	// EFS Filesystem gets created in the same region as this instance.
 	metadata := ec2metadata.New(&ec2metadata.Config{})
-	region, err := metadata.Region()
-	if err != nil {
-		panic(err)
+	d.Region = CurrentConfig().Global.Get(optRegion, "")
+	if d.Region == "" {
+		region, err := metadata.Region()
+		if err != nil {
+			return d, err
+		}
+		d.Region = region
 	}
 
 	// We need to determine which region this host lives in. That will allow us to spin
 	// up EFS Filesystem within this region.
-	e := ec2.New(&aws.Config{Region: aws.String(region)})
+	e := NewEC2(d.Region, log.WithField("region", d.Region))
 
 	i, err := metadata.GetMetadata("instance-id")
 	if err != nil {
-		panic(err)
+		return d, err
 	}
+	instanceId = i
 
-	subnet, err := GetSubnet(e, i)
+	d.Zone, err = metadata.GetMetadata("placement/availability-zone")
 	if err != nil {
-		panic(err)
+		return d, err
 	}
 
-	d := DriverEFS{
-		Root:   *cliRoot,
-		Region: region,
-		Subnet: subnet,
+	d.Subnet, err = GetSubnet(e, i)
+	if err != nil {
+		return d, err
 	}
-	h := dkvolume.NewHandler(d)
-	log.Printf("Listening: %s", socketAddress)
-	log.Println(h.ServeUnix("root", socketAddress))
+
+	return d, nil
+}
+
+// Reload applies configuration changes without restarting the plugin. The
+// region and cleanup interval are only read at startup.
+func Reload() error {
+	if err := SetupLogging(); err != nil {
+		return err
+	}
+	return ReloadConfig()
 }
 
 func Cleanup(d string) {
-	log.Println("Running cleanup task")
+	l := log.WithField("task", "cleanup")
+	l.Debug("Running cleanup task")
 
 	// Get a list of all the current running containers.
 	mounts, err := GetDockerBinds()
 	if err != nil {
-		log.Println(err)
+		l.WithField("error", err).Error("Cannot list Docker binds")
+		metricCleanupRuns.Inc(Result(err))
 		return
 	}
+	defer func() {
+		metricMounted.Set(float64(CountMounts(d)))
+	}()
 
 	// Go over the list of possible mounts and compare against the Docker running
 	// containers list.
@@ -156,7 +856,10 @@ func Cleanup(d string) {
 		// We only deal with directories which are also mounts.
 		nfs, err := mount.Mounted(p)
 		if err != nil {
-			log.Printf("Cannot determine if mounted %s", m)
+			l.WithFields(log.Fields{
+				"volume": m,
+				"error":  err,
+			}).Warn("Cannot determine if mounted")
 			continue
 		}
 		if !nfs {
@@ -171,9 +874,17 @@ func Cleanup(d string) {
 
 		err = Exec("umount", p)
 		if err != nil {
-			log.Printf("Cleanup failed: %s", m)
+			l.WithFields(log.Fields{
+				"volume": m,
+				"error":  err,
+			}).Error("Cleanup failed")
+			metricCleanupUnmount.Inc(Result(err))
+			metricCleanupRuns.Inc(Result(err))
 			return
 		}
-		log.Printf("Cleaned: %s", m)
+		metricCleanupUnmount.Inc(Result(nil))
+		l.WithField("volume", m).Info("Cleaned")
 	}
+
+	metricCleanupRuns.Inc(Result(nil))
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// Helper function to create a driver which talks to a fake EFS API.
+func newFakeDriver(t *testing.T, filesystems []*taggedFileSystem) (DriverEFS, *int) {
+	e, requests := newFakeEFS(t, filesystems)
+
+	efsClientsMu.Lock()
+	efsClients["us-east-1||"] = e
+	efsClientsMu.Unlock()
+	t.Cleanup(func() {
+		efsClientsMu.Lock()
+		delete(efsClients, "us-east-1||")
+		efsClientsMu.Unlock()
+	})
+
+	d := DriverEFS{
+		Root:   t.TempDir(),
+		Region: "us-east-1",
+		Cache:  NewMountCache(time.Minute),
+	}
+	return d, requests
+}
+
+func TestVolumeStatus(t *testing.T) {
+	p := CurrentConfig().Policy
+	filesystems := []*taggedFileSystem{{
+		FileSystemId:  aws.String("fs-11111111"),
+		CreationToken: aws.String(p.Token("foo")),
+		Name:          aws.String("foo"),
+		SizeInBytes:   &efs.FileSystemSize{Value: aws.Int64(2 << 30)},
+		Tags:          append(OptionTags(Options{optQuota: "1GiB"}), p.Tags()...),
+	}}
+	l := log.WithField("test", t.Name())
+
+	check := func(t *testing.T, v *Volume) {
+		if v.Status["filesystemId"] != "fs-11111111" {
+			t.Errorf("filesystemId = %v, want fs-11111111", v.Status["filesystemId"])
+		}
+		if v.Status["quotaExceeded"] != true {
+			t.Errorf("quotaExceeded = %v, want true", v.Status["quotaExceeded"])
+		}
+		if v.Status[optQuota] != "1GiB" {
+			t.Errorf("%s = %v, want 1GiB", optQuota, v.Status[optQuota])
+		}
+	}
+
+	t.Run("get", func(t *testing.T) {
+		d, requests := newFakeDriver(t, filesystems)
+		check(t, d.volume(l, "foo"))
+
+		// Described again from the cache.
+		n := *requests
+		check(t, d.volume(l, "foo"))
+		if *requests != n {
+			t.Errorf("describing a volume again sent %d requests, want 0", *requests-n)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		d, requests := newFakeDriver(t, filesystems)
+		if names := d.KnownVolumes(l); !names["foo"] {
+			t.Fatalf("got %v, want foo", names)
+		}
+
+		// Described from the listing.
+		n := *requests
+		check(t, d.volume(l, "foo"))
+		if *requests != n {
+			t.Errorf("describing a listed volume sent %d requests, want 0", *requests-n)
+		}
+	})
+}
+
+func TestMountableTargetFresh(t *testing.T) {
+	p := CurrentConfig().Policy
+	d, _ := newFakeDriver(t, []*taggedFileSystem{{
+		FileSystemId:  aws.String("fs-11111111"),
+		CreationToken: aws.String(p.Token("foo")),
+		Tags:          p.Tags(),
+	}})
+	l := log.WithField("test", t.Name())
+
+	// The mount target was recreated with another IP since it was cached.
+	d.Cache.Put("foo", d.Zone, Options{}, &efs.MountTargetDescription{
+		FileSystemId: aws.String("fs-11111111"),
+		IpAddress:    aws.String("10.0.0.2"),
+	})
+	for _, tt := range []struct {
+		fresh bool
+		want  string
+	}{
+		{false, "10.0.0.2"},
+		{true, "10.0.0.1"},
+	} {
+		_, m, err := d.MountableTarget(l, "foo", tt.fresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := aws.StringValue(m.IpAddress); got != tt.want {
+			t.Errorf("fresh=%v: got %s, want %s", tt.fresh, got, tt.want)
+		}
+	}
+}
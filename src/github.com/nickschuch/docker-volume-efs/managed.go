@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+)
+
+// When running as a Docker managed plugin the plugin has its own mount
+// namespace, and only mounts under the propagated mount (declared in the
+// plugin config.json) are seen by dockerd. Volumes have to be mounted under it,
+// and dockerd translates the mountpoints we respond with to the host.
+
+var (
+	cliPropagatedMount = kingpin.Flag("propagated-mount", "Propagated mount of the managed plugin, which volumes are mounted under.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_PROPAGATED_MOUNT").String()
+)
+
+// Helper function to get the volumes root directory. It defaults to the
+// propagated mount when running as a managed plugin, and has to be inside it.
+func RootDir(root, propagated string) (string, error) {
+	if propagated == "" {
+		return root, nil
+	}
+	if root == defaultDir {
+		return filepath.Clean(propagated), nil
+	}
+
+	rel, err := filepath.Rel(propagated, root)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("root %s is not inside the propagated mount %s", root, propagated)
+	}
+	return filepath.Clean(root), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/alecthomas/kingpin"
+)
+
+// Settings of a managed plugin can only be changed through the environment
+// variables its config.json declares.
+func TestPluginConfigEnv(t *testing.T) {
+	data, err := ioutil.ReadFile("../../../../plugin/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Env []struct {
+			Name string
+		}
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	declared := make(map[string]bool)
+	for _, e := range config.Env {
+		declared[e.Name] = true
+	}
+
+	model := kingpin.CommandLine.Model()
+	flags := model.Flags
+	for _, c := range model.Commands {
+		flags = append(flags, c.Flags...)
+		for _, s := range c.Commands {
+			flags = append(flags, s.Flags...)
+		}
+	}
+	for _, f := range flags {
+		if f.Envar != "" && !declared[f.Envar] {
+			t.Errorf("--%s: %s is not declared in config.json", f.Name, f.Envar)
+		}
+	}
+}
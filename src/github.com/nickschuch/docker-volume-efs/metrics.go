@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+)
+
+const (
+	metricsNamespace = "docker_volume_efs"
+)
+
+var (
+	cliMetricsAddr = kingpin.Flag("metrics-addr", "Address to expose Prometheus metrics and health endpoints on (eg. :9100). Disabled when empty.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_METRICS_ADDR").String()
+
+	// Default histogram buckets (seconds). EFS provisioning can take minutes so
+	// the buckets reach further than the usual HTTP latency buckets.
+	defaultBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+	metrics = NewRegistry()
+
+	metricDriverCalls    = metrics.Counter("driver_calls_total", "VolumeDriver calls by method and result.", "method", "result")
+	metricDriverDuration = metrics.Histogram("driver_call_duration_seconds", "VolumeDriver call latency by method and result.", "method", "result")
+	metricAWSCalls       = metrics.Counter("aws_calls_total", "AWS API calls by service, operation and result.", "service", "operation", "result")
+	metricAWSDuration    = metrics.Histogram("aws_call_duration_seconds", "AWS API call latency by service and operation.", "service", "operation")
+	metricAWSThrottles   = metrics.Counter("aws_throttles_total", "AWS API calls throttled by AWS (and retried) by service and operation.", "service", "operation")
+	metricAWSRateLimited = metrics.Histogram("aws_rate_limit_wait_seconds", "Time AWS API calls waited for the client-side rate limit by service.", "service")
+	metricProvisionWait  = metrics.Histogram("provision_wait_seconds", "Time spent waiting for EFS resources to become available.", "resource")
+	metricCleanupRuns    = metrics.Counter("cleanup_runs_total", "Cleanup task runs by result.", "result")
+	metricCleanupUnmount = metrics.Counter("cleanup_unmounts_total", "Volumes unmounted by the cleanup task by result.", "result")
+	metricRecoveries     = metrics.Counter("stale_recoveries_total", "Stale mounts detached and remounted by reason and result.", "reason", "result")
+	metricMounted        = metrics.Gauge("mounted_volumes", "Volumes currently mounted on this host.")
+	metricReferences     = metrics.Gauge("active_references", "Active Mount references handed out to containers.")
+	metricMountCache     = metrics.Counter("mount_cache_total", "Mount target cache lookups by result (hit, miss).", "result")
+	metricStatusCache    = metrics.Counter("status_cache_total", "Volume description cache lookups by result (hit, miss).", "result")
+	metricDNSFallbacks   = metrics.Counter("dns_fallbacks_total", "Mounts by IP because the DNS name of the filesystem didn't resolve.")
+	metricUsage          = metrics.GaugeVec("volume_usage_bytes", "Metered size of the EFS Filesystem of each mounted volume.", "volume")
+	metricQuota          = metrics.GaugeVec("volume_quota_bytes", "Quota of each mounted volume which has one.", "volume")
+	metricQuotaExceeded  = metrics.Counter("quota_exceeded_total", "Usage checks which found a volume over its quota by quota action.", "action")
+)
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Helper function to create an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a new counter partitioned by the given labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{series: newSeries(name, help, labels)}
+	r.add(c)
+	return c
+}
+
+// Histogram registers a new histogram partitioned by the given labels.
+func (r *Registry) Histogram(name, help string, labels ...string) *Histogram {
+	h := &Histogram{series: newSeries(name, help, labels), buckets: defaultBuckets}
+	r.add(h)
+	return h
+}
+
+// Gauge registers a new gauge without labels.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{series: newSeries(name, help, nil)}
+	r.add(g)
+	return g
+}
+
+// GaugeVec registers a new gauge partitioned by the given labels.
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{series: newSeries(name, help, labels)}
+	r.add(g)
+	return g
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP renders all registered metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// Common name, help and label handling shared by all metric types.
+type series struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+}
+
+func newSeries(name, help string, labels []string) series {
+	return series{
+		name:   metricsNamespace + "_" + name,
+		help:   help,
+		labels: labels,
+	}
+}
+
+func (s *series) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.name, s.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.name, kind)
+}
+
+// Helper function to build a stable key for a set of label values.
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", s.name, len(s.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Helper function to render a set of label pairs, with optional extra pairs.
+func (s *series) format(key string, extra ...string) string {
+	var pairs []string
+	if len(s.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", s.labels[i], v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	series
+	values map[string]float64
+}
+
+// Inc increments the counter for the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter for the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	k := c.key(values)
+
+	c.Lock()
+	defer c.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]float64)
+	}
+	c.values[k] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %v\n", c.name, c.format(k), c.values[k])
+	}
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	series
+	value float64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.Lock()
+	defer g.Unlock()
+	g.value = v
+}
+
+// Add adds v (which may be negative) to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.Lock()
+	defer g.Unlock()
+	g.value += v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %v\n", g.name, g.value)
+}
+
+// GaugeVec is a value which can go up and down for each set of label values.
+type GaugeVec struct {
+	series
+	values map[string]float64
+}
+
+// Set sets the gauge for the given label values to v.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	k := g.key(values)
+
+	g.Lock()
+	defer g.Unlock()
+	if g.values == nil {
+		g.values = make(map[string]float64)
+	}
+	g.values[k] = v
+}
+
+// Delete removes the gauge for the given label values.
+func (g *GaugeVec) Delete(values ...string) {
+	k := g.key(values)
+
+	g.Lock()
+	defer g.Unlock()
+	delete(g.values, k)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.header(w, "gauge")
+	for _, k := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %v\n", g.name, g.format(k), g.values[k])
+	}
+}
+
+// Histogram tracks the distribution of observed values.
+type Histogram struct {
+	series
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v for the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	k := h.key(values)
+
+	h.Lock()
+	defer h.Unlock()
+	if h.values == nil {
+		h.values = make(map[string]*histogramValue)
+	}
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Since records the seconds elapsed since t for the given label values.
+func (h *Histogram) Since(t time.Time, values ...string) {
+	h.Observe(time.Since(t).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.header(w, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.format(k, "le", fmt.Sprintf("%v", b)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.format(k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, h.format(k), hv.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.format(k), hv.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Helper function to map an error onto a metric result label.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Helper function to expose the metrics and health endpoints on the given
+// address.
+func ServeStatus(addr string, hc *HealthChecker) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/healthz", hc.ServeHealthz)
+	mux.HandleFunc("/volumes", hc.ServeVolumes)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Volume names are used as directory names under --root.
+	maxNameLength = 255
+
+	// EFS limits CreationTokens to 64 characters. Tokens which don't fit are
+	// truncated and end with a hash of the full name (64 bits of it) so they
+	// stay unique. Only hashed tokens use all 64 characters, so they can't
+	// collide with the token of a shorter name.
+	maxTokenLength  = 64
+	tokenHashLength = 16
+
+	// The namespace must leave room for a useful part of the name.
+	maxNamespaceLength = 32
+
+	// Separates the namespace from the name in a CreationToken. Names and
+	// namespaces can't contain it, so each token has one namespace and name.
+	tokenSeparator = ":"
+)
+
+var (
+	// The characters Docker allows in volume names. These are also safe to use
+	// as a directory name and in a CreationToken.
+	nameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Helper function to check that a volume name is safe to use as a directory
+// under --root and in a CreationToken.
+func ValidateName(n string) error {
+	if n == "" {
+		return fmt.Errorf("volume name is required")
+	}
+	if len(n) > maxNameLength {
+		return fmt.Errorf("volume name %s... is too long: %d characters (max %d)", n[:32], len(n), maxNameLength)
+	}
+	if !nameRegex.MatchString(n) {
+		return fmt.Errorf("invalid volume name %s: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", n)
+	}
+	return nil
+}
+
+// Helper function to check that a namespace can prefix CreationTokens.
+func ValidateNamespace(ns string) error {
+	if ns == "" {
+		return nil
+	}
+	if len(ns) > maxNamespaceLength {
+		return fmt.Errorf("invalid %s: %s is too long (max %d)", optNamespace, ns, maxNamespaceLength)
+	}
+	if !nameRegex.MatchString(ns) {
+		return fmt.Errorf("invalid %s: %s: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", optNamespace, ns)
+	}
+	return nil
+}
+
+// Helper function to encode a volume name as a CreationToken. Tokens which
+// fit are the name (after the namespace and a separator, if there is one),
+// so existing filesystems keep their token. Longer ones are truncated and
+// suffixed with a hash.
+func EncodeToken(ns, n string) string {
+	t := tokenPrefix(ns) + n
+	if len(t) < maxTokenLength {
+		return t
+	}
+
+	sum := sha256.Sum256([]byte(n))
+	hash := hex.EncodeToString(sum[:])[:tokenHashLength]
+	return t[:maxTokenLength-tokenHashLength-1] + "-" + hash
+}
+
+// Helper function to decode a CreationToken back into a volume name. Hashed
+// tokens can't be reversed (they only hold the start of the name), so the
+// volume name recorded in the Name tag is checked against the token instead.
+func DecodeToken(ns, t, name string) (string, bool) {
+	prefix := tokenPrefix(ns)
+	if !strings.HasPrefix(t, prefix) {
+		return "", false
+	}
+
+	candidates := []string{name}
+	if len(t) < maxTokenLength {
+		candidates = append(candidates, strings.TrimPrefix(t, prefix))
+	}
+	for _, n := range candidates {
+		if ValidateName(n) == nil && EncodeToken(ns, n) == t {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// Helper function to get the namespace of a CreationToken, if it has one.
+func TokenNamespace(t string) (string, bool) {
+	i := strings.Index(t, tokenSeparator)
+	if i < 1 || ValidateNamespace(t[:i]) != nil {
+		return "", false
+	}
+	return t[:i], true
+}
+
+// Helper function to get the start of the CreationTokens in a namespace.
+func tokenPrefix(ns string) string {
+	if ns == "" {
+		return ""
+	}
+	return ns + tokenSeparator
+}
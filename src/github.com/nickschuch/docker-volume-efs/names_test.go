@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"foo", true},
+		{"foo.bar_baz-1", true},
+		{"1foo", true},
+		{strings.Repeat("a", maxNameLength), true},
+		{"", false},
+		{".foo", false},
+		{"-foo", false},
+		{"_foo", false},
+		{"foo/bar", false},
+		{"..", false},
+		{"foo bar", false},
+		{"foo:bar", false},
+		{strings.Repeat("a", maxNameLength+1), false},
+	}
+	for _, tt := range tests {
+		if err := ValidateName(tt.name); tt.valid != (err == nil) {
+			t.Errorf("ValidateName(%q) = %v", tt.name, err)
+		}
+	}
+}
+
+func TestEncodeToken(t *testing.T) {
+	long := strings.Repeat("a", 63)
+
+	tests := []struct {
+		name   string
+		ns     string
+		volume string
+		hashed bool
+	}{
+		{"short", "", "foo", false},
+		{"punctuation", "", "foo.bar_baz-1", false},
+		{"namespace", "prod", "foo", false},
+		{"63 characters", "", long, false},
+		{"64 characters", "", long + "a", true},
+		{"namespace makes it too long", "prod", long, true},
+		{"255 characters", "prod", strings.Repeat("a._-", 63) + "abc", true},
+	}
+	for _, tt := range tests {
+		tok := EncodeToken(tt.ns, tt.volume)
+		if len(tok) > maxTokenLength {
+			t.Errorf("%s: token %s is %d characters", tt.name, tok, len(tok))
+		}
+		if !strings.HasPrefix(tok, tokenPrefix(tt.ns)) {
+			t.Errorf("%s: token %s doesn't start with the namespace", tt.name, tok)
+		}
+		if hashed := len(tok) == maxTokenLength; hashed != tt.hashed {
+			t.Errorf("%s: token %s hashed = %t, want %t", tt.name, tok, hashed, tt.hashed)
+		}
+		if !tt.hashed && tok != tokenPrefix(tt.ns)+tt.volume {
+			t.Errorf("%s: token %s, want %s", tt.name, tok, tokenPrefix(tt.ns)+tt.volume)
+		}
+
+		// The round trip needs the Name tag for hashed tokens.
+		if n, ok := DecodeToken(tt.ns, tok, tt.volume); !ok || n != tt.volume {
+			t.Errorf("%s: DecodeToken(%s) = %q, %t", tt.name, tok, n, ok)
+		}
+		if n, ok := DecodeToken(tt.ns, tok, ""); ok == tt.hashed {
+			t.Errorf("%s: DecodeToken(%s) without a Name tag = %q, %t", tt.name, tok, n, ok)
+		}
+	}
+}
+
+func TestEncodeTokenCollisions(t *testing.T) {
+	prefix := strings.Repeat("x", 70)
+
+	// Names which only differ after the truncated part get different tokens.
+	seen := make(map[string]string)
+	for _, suffix := range []string{"a", "b", "a.", "a_", "a-", "aa"} {
+		n := prefix + suffix
+		tok := EncodeToken("", n)
+		if other, ok := seen[tok]; ok {
+			t.Errorf("%s and %s have the same token %s", n, other, tok)
+		}
+		seen[tok] = n
+	}
+
+	// A hashed token isn't the token of a shorter name.
+	tok := EncodeToken("", prefix)
+	if n, ok := DecodeToken("", tok, tok); ok {
+		t.Errorf("DecodeToken(%s) = %q", tok, n)
+	}
+
+	// A Name tag with the same start as the name doesn't match the token.
+	if n, ok := DecodeToken("", tok, prefix+"a"); ok {
+		t.Errorf("DecodeToken(%s) with another name = %q", tok, n)
+	}
+
+	// Tokens in another namespace aren't decoded.
+	if n, ok := DecodeToken("prod-", EncodeToken("dev-", "foo"), "foo"); ok {
+		t.Errorf("DecodeToken of another namespace = %q", n)
+	}
+}
+
+func TestEncodeTokenNamespaces(t *testing.T) {
+	long := strings.Repeat("x", 70)
+
+	// Namespaces and names which run into each other get different tokens.
+	tests := []struct {
+		ns, volume string
+	}{
+		{"", "team-a-db"},
+		{"team-", "a-db"},
+		{"team-a-", "db"},
+		{"team", "a-db"},
+		{"team-a", "db"},
+		{"", "team-a-" + long},
+		{"team-", "a-" + long},
+		{"team-a-", long},
+	}
+
+	seen := make(map[string]int)
+	for i, tt := range tests {
+		tok := EncodeToken(tt.ns, tt.volume)
+		if j, ok := seen[tok]; ok {
+			t.Errorf("%q %q and %q %q have the same token %s", tt.ns, tt.volume, tests[j].ns, tests[j].volume, tok)
+		}
+		seen[tok] = i
+
+		// Only decoded in its own namespace, even with a Name tag.
+		for _, other := range tests {
+			if other.ns == tt.ns {
+				continue
+			}
+			if n, ok := DecodeToken(other.ns, tok, tt.volume); ok {
+				t.Errorf("DecodeToken(%q, %s) of namespace %q = %q", other.ns, tok, tt.ns, n)
+			}
+			if n, ok := DecodeToken(other.ns, tok, other.volume); ok {
+				t.Errorf("DecodeToken(%q, %s) of namespace %q with Name %s = %q", other.ns, tok, tt.ns, other.volume, n)
+			}
+		}
+	}
+}
+
+func TestTokenNamespace(t *testing.T) {
+	tests := []struct {
+		token, ns string
+		ok        bool
+	}{
+		{EncodeToken("prod", "foo"), "prod", true},
+		{EncodeToken("prod", strings.Repeat("a", 70)), "prod", true},
+		{EncodeToken("", "foo"), "", false},
+		{"terraform-20240101120000000000000001", "", false},
+		{":foo", "", false},
+		{"my stack:foo", "", false},
+	}
+	for _, tt := range tests {
+		if ns, ok := TokenNamespace(tt.token); ns != tt.ns || ok != tt.ok {
+			t.Errorf("TokenNamespace(%s) = %q, %t, want %q, %t", tt.token, ns, ok, tt.ns, tt.ok)
+		}
+	}
+}
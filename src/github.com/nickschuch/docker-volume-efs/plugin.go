@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// The vendored docker-volume-api handler only understands the original
+// Create/Remove/Path/Mount/Unmount calls and ignores everything except the
+// volume name. This is our own implementation of the VolumeDriver protocol
+// so we can also serve Get, List and Capabilities.
+
+const (
+	pluginContentType = "application/vnd.docker.plugins.v1+json"
+	pluginManifest    = `{"Implements": ["VolumeDriver"]}`
+)
+
+// Request is the structure that Docker's requests are deserialized to.
+type Request struct {
+	Name    string
+	Options map[string]string `json:"Opts,omitempty"`
+	ID      string            `json:",omitempty"`
+}
+
+// Volume describes a single volume for Get and List calls.
+type Volume struct {
+	Name       string
+	Mountpoint string                 `json:",omitempty"`
+	Status     map[string]interface{} `json:",omitempty"`
+}
+
+// Capabilities describes the driver to Docker. A global scope means a volume
+// is the same on every host, so Docker (and Swarm) only needs to create it
+// once and can use it from any node.
+type Capabilities struct {
+	Scope string
+}
+
+// Response is the structure that the plugin's responses are serialized to.
+type Response struct {
+	Mountpoint   string        `json:",omitempty"`
+	Err          string        `json:",omitempty"`
+	Volume       *Volume       `json:",omitempty"`
+	Volumes      []*Volume     `json:",omitempty"`
+	Capabilities *Capabilities `json:",omitempty"`
+}
+
+// Driver represents the interface a volume driver must fulfill.
+type Driver interface {
+	Create(Request) Response
+	Remove(Request) Response
+	Path(Request) Response
+	Mount(Request) Response
+	Unmount(Request) Response
+	Get(Request) Response
+	List(Request) Response
+	Capabilities(Request) Response
+}
+
+// Handler forwards requests and responses between Docker and a Driver.
+type Handler struct {
+	driver Driver
+	mux    *http.ServeMux
+}
+
+// Helper function to initialize a request handler for a driver.
+func NewHandler(d Driver) *Handler {
+	h := &Handler{
+		driver: d,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", pluginContentType)
+		fmt.Fprintln(w, pluginManifest)
+	})
+	h.handle("/VolumeDriver.Create", d.Create)
+	h.handle("/VolumeDriver.Remove", d.Remove)
+	h.handle("/VolumeDriver.Path", d.Path)
+	h.handle("/VolumeDriver.Mount", d.Mount)
+	h.handle("/VolumeDriver.Unmount", d.Unmount)
+	h.handle("/VolumeDriver.Get", d.Get)
+	h.handle("/VolumeDriver.List", d.List)
+	h.handle("/VolumeDriver.Capabilities", d.Capabilities)
+
+	return h
+}
+
+func (h *Handler) handle(path string, call func(Request) Response) {
+	h.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+
+		// Some calls (eg. List) are made without a body.
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res := call(req)
+
+		w.Header().Set("Content-Type", pluginContentType)
+		if res.Err != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// ServeHTTP dispatches a plugin API call.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Helper function to create the plugin unix socket, replacing a stale socket
+// left behind by a previous run.
+func NewUnixSocket(group, addr string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+		return nil, err
+	}
+	if err := syscall.Unlink(addr); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	mask := syscall.Umask(0777)
+	defer syscall.Umask(mask)
+
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if err := os.Chown(addr, -1, gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	if err := os.Chmod(addr, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Helper function to serve the plugin API on a unix socket, as Docker calls
+// it, and return a client for it.
+func newPluginClient(t *testing.T, d Driver) *http.Client {
+	addr := filepath.Join(t.TempDir(), "efs.sock")
+	l, err := NewUnixSocket("", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, NewHandler(d))
+	t.Cleanup(func() { l.Close() })
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", addr)
+			},
+		},
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	c := newPluginClient(t, NewInstrumentedDriver(DriverEFS{}, t.TempDir()))
+
+	// Docker makes the call without a body.
+	resp, err := c.Post("http://plugin/VolumeDriver.Capabilities", pluginContentType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != pluginContentType {
+		t.Errorf("got content type %s, want %s", ct, pluginContentType)
+	}
+
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Err != "" {
+		t.Errorf("got error %s", res.Err)
+	}
+	if res.Capabilities == nil || res.Capabilities.Scope != "global" {
+		t.Errorf("got capabilities %+v, want global scope", res.Capabilities)
+	}
+}
+
+func TestActivate(t *testing.T) {
+	c := newPluginClient(t, DriverEFS{})
+
+	resp, err := c.Post("http://plugin/Plugin.Activate", pluginContentType, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var manifest struct{ Implements []string }
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Implements) != 1 || manifest.Implements[0] != "VolumeDriver" {
+		t.Errorf("got %v, want [VolumeDriver]", manifest.Implements)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+const (
+	// Global settings which restrict the volumes this host can use. These are
+	// only read from the [global] section (and flags) so a volume can't
+	// loosen them with its own options.
+	optNamespace      = "namespace"
+	optAllowNames     = "allow_names"
+	optDenyNames      = "deny_names"
+	optMaxFilesystems = "max_filesystems"
+	optAdoptFsids     = "adopt_fsids"
+
+	// Marks the EFS Filesystems created by the plugin, with the namespace they
+	// were created in. CreationTokens alone can't tell them apart from other
+	// filesystems (eg. Terraform's terraform-<timestamp> tokens).
+	tagNamespace = tagPrefix + "namespace"
+)
+
+var (
+	// Options which a volume can't set for itself.
+	globalOptions = []string{optNamespace, optAllowNames, optDenyNames, optMaxFilesystems, optAdoptFsids, optOwnerLabel}
+
+	cliNamespace      = kingpin.Flag("namespace", "Prefix added to the CreationToken of EFS Filesystems, so hosts in different namespaces can't use each other's volumes.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_NAMESPACE").String()
+	cliAllowNames     = kingpin.Flag("allow-names", "Regular expression which volume names must match.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_ALLOW_NAMES").String()
+	cliDenyNames      = kingpin.Flag("deny-names", "Regular expression which volume names must not match.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_DENY_NAMES").String()
+	cliMaxFilesystems = kingpin.Flag("max-filesystems", "Maximum number of EFS Filesystems managed by this plugin (0 is unlimited).").Default("0").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_MAX_FILESYSTEMS").Int()
+	cliAdoptFsids     = kingpin.Flag("adopt-fsids", "Comma separated IDs of the EFS Filesystems which volumes can adopt with -o fsid.").Default("").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_ADOPT_FSIDS").String()
+)
+
+// Policy restricts which volumes can be created and mounted.
+type Policy struct {
+	Namespace      string
+	Allow          *regexp.Regexp
+	Deny           *regexp.Regexp
+	MaxFilesystems int
+	Adoptable      []string
+}
+
+// Helper function to build the policy from the global settings.
+func NewPolicy(o Options) (*Policy, error) {
+	p := &Policy{
+		Namespace: o.Get(optNamespace, ""),
+	}
+	if err := ValidateNamespace(p.Namespace); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.Allow, err = namePattern(o, optAllowNames); err != nil {
+		return nil, err
+	}
+	if p.Deny, err = namePattern(o, optDenyNames); err != nil {
+		return nil, err
+	}
+
+	if v := o.Get(optMaxFilesystems, ""); v != "" {
+		p.MaxFilesystems, err = strconv.Atoi(v)
+		if err != nil || p.MaxFilesystems < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", optMaxFilesystems, v)
+		}
+	}
+
+	p.Adoptable = o.List(optAdoptFsids)
+	for _, id := range p.Adoptable {
+		if err := ValidateFsid(id); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", optAdoptFsids, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Helper function to compile a volume name pattern. Patterns must match the
+// whole name.
+func namePattern(o Options, key string) (*regexp.Regexp, error) {
+	v := o.Get(key, "")
+	if v == "" {
+		return nil, nil
+	}
+	r, err := regexp.Compile("^(?:" + v + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", key, err)
+	}
+	return r, nil
+}
+
+// Check returns an error if a volume name is invalid or not allowed.
+func (p *Policy) Check(n string) error {
+	if err := ValidateName(n); err != nil {
+		return err
+	}
+	if p.Allow != nil && !p.Allow.MatchString(n) {
+		return fmt.Errorf("volume name %s is not allowed: must match %s", n, p.Allow)
+	}
+	if p.Deny != nil && p.Deny.MatchString(n) {
+		return fmt.Errorf("volume name %s is not allowed: must not match %s", n, p.Deny)
+	}
+	return nil
+}
+
+// Token returns the CreationToken of the EFS Filesystem for a volume.
+func (p *Policy) Token(n string) string {
+	return EncodeToken(p.Namespace, n)
+}
+
+// Tags returns the tags which mark an EFS Filesystem as created by this plugin
+// in this namespace.
+func (p *Policy) Tags() []*efs.Tag {
+	return []*efs.Tag{{Key: aws.String(tagNamespace), Value: aws.String(p.Namespace)}}
+}
+
+// Name returns the volume an EFS Filesystem (with its tags) was created for by
+// this plugin in this namespace. These are named after the volume they were
+// created for.
+func (p *Policy) Name(fs *efs.FileSystemDescription, tags []*efs.Tag) (string, bool) {
+	if ns, ok := Marked(tags); !ok || ns != p.Namespace {
+		return "", false
+	}
+	return DecodeToken(p.Namespace, aws.StringValue(fs.CreationToken), aws.StringValue(fs.Name))
+}
+
+// Managed returns true if an EFS Filesystem (with its tags) was created by
+// this plugin in this namespace.
+func (p *Policy) Managed(fs *efs.FileSystemDescription, tags []*efs.Tag) bool {
+	_, ok := p.Name(fs, tags)
+	return ok
+}
+
+// NotManaged returns the error for an EFS Filesystem which has the
+// CreationToken of a volume, but wasn't created by this plugin in this
+// namespace (eg. by Terraform, or an earlier version of the plugin).
+func (p *Policy) NotManaged(id, n string) error {
+	return fmt.Errorf("EFS Filesystem %s has the CreationToken of volume %s but wasn't created by the plugin in this namespace (tag it %s=%s, or bind it in [aliases])", id, n, tagNamespace, p.Namespace)
+}
+
+// Limit returns an error if creating another EFS Filesystem would exceed the
+// maximum number of managed filesystems.
+func (p *Policy) Limit(e *efs.EFS) error {
+	if p.MaxFilesystems == 0 {
+		return nil
+	}
+
+	filesystems, err := listFileSystems(e)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	for _, fs := range filesystems {
+		if p.Managed(fs.Description(), fs.Tags) {
+			count++
+		}
+	}
+	if count >= p.MaxFilesystems {
+		return fmt.Errorf("cannot create another EFS Filesystem: %d of %d (%s) already exist", count, p.MaxFilesystems, optMaxFilesystems)
+	}
+
+	return nil
+}
+
+// Helper function to get the namespace an EFS Filesystem was created in by
+// the plugin (in any namespace), from its tags.
+func Marked(tags []*efs.Tag) (string, bool) {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == tagNamespace {
+			return aws.StringValue(t.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+func TestPolicyManaged(t *testing.T) {
+	p := &Policy{}
+	prod := &Policy{Namespace: "prod"}
+
+	tests := []struct {
+		name    string
+		token   string
+		tags    []*efs.Tag
+		managed string
+		prod    string
+	}{
+		{
+			name:    "created by the plugin",
+			token:   p.Token("foo"),
+			tags:    p.Tags(),
+			managed: "foo",
+		},
+		{
+			name:  "created by the plugin in a namespace",
+			token: prod.Token("foo"),
+			tags:  prod.Tags(),
+			prod:  "foo",
+		},
+		{
+			name:  "marked with another namespace",
+			token: p.Token("foo"),
+			tags:  prod.Tags(),
+		},
+		{
+			name:  "terraform",
+			token: "terraform-20240101120000000000000001",
+			tags:  []*efs.Tag{{Key: aws.String(tagName), Value: aws.String("shared")}},
+		},
+		{
+			name:  "console",
+			token: "console-1f0e7a52-3c4b-4d1e-9a8f-2b6c5d4e3f21",
+		},
+		{
+			name:  "token of a volume without the mark",
+			token: p.Token("foo"),
+		},
+	}
+
+	for _, tt := range tests {
+		fs := &efs.FileSystemDescription{CreationToken: aws.String(tt.token), Name: aws.String("foo")}
+		for _, c := range []struct {
+			p    *Policy
+			want string
+		}{{p, tt.managed}, {prod, tt.prod}} {
+			n, ok := c.p.Name(fs, tt.tags)
+			if ok != (c.want != "") || n != c.want {
+				t.Errorf("%s: Name in namespace %q = %q, %t, want %q", tt.name, c.p.Namespace, n, ok, c.want)
+			}
+		}
+	}
+}
+
+func TestPolicyLimit(t *testing.T) {
+	p := &Policy{MaxFilesystems: 2}
+	e, _ := newFakeEFS(t, []*taggedFileSystem{
+		{FileSystemId: aws.String("fs-11111111"), CreationToken: aws.String(p.Token("foo")), Tags: p.Tags()},
+		{FileSystemId: aws.String("fs-22222222"), CreationToken: aws.String("terraform-20240101120000000000000001")},
+		{FileSystemId: aws.String("fs-33333333"), CreationToken: aws.String("console-1f0e7a52-3c4b-4d1e-9a8f-2b6c5d4e3f21")},
+	})
+
+	// Only the filesystems created by the plugin count.
+	if err := p.Limit(e); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+	p.MaxFilesystems = 1
+	if err := p.Limit(e); err == nil {
+		t.Error("got no error at the limit")
+	}
+}
+
+func TestFindFilesystem(t *testing.T) {
+	p := &Policy{}
+	token := "terraform-20240101120000000000000001"
+	e, _ := newFakeEFS(t, []*taggedFileSystem{
+		{FileSystemId: aws.String("fs-11111111"), CreationToken: aws.String(p.Token("foo")), Tags: p.Tags()},
+		{FileSystemId: aws.String("fs-22222222"), CreationToken: aws.String(token)},
+	})
+
+	fs, tags, err := FindFilesystem(e, p, "", "foo")
+	if err != nil || fs == nil || *fs.FileSystemId != "fs-11111111" || len(tags) != 1 {
+		t.Errorf("foo: got %v, %v, %v, want fs-11111111", fs, tags, err)
+	}
+
+	// A volume named after the CreationToken of another filesystem isn't
+	// given that filesystem.
+	if fs, _, err := FindFilesystem(e, p, "", token); err == nil {
+		t.Errorf("%s: got %v, want an error", token, fs)
+	}
+
+	if fs, _, err := FindFilesystem(e, p, "", "missing"); err != nil || fs != nil {
+		t.Errorf("missing: got %v, %v, want nothing", fs, err)
+	}
+}
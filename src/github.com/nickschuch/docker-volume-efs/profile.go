@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+const (
+	// Filesystem options, applied when the filesystem is created.
+	optPerformanceMode       = "performance_mode"
+	optThroughputMode        = "throughput_mode"
+	optProvisionedThroughput = "provisioned_throughput"
+	optEncrypted             = "encrypted"
+	optKmsKeyId              = "kms_key_id"
+	optTransitionToIA        = "transition_to_ia"
+
+	// Mount options, applied each time the volume is mounted. These use the
+	// amazon-efs-utils mount helper.
+	optTLS = "tls"
+	optIAM = "iam"
+
+	// Tags which record how a volume was created so later Mount, Get and
+	// List calls (which don't receive options) can see them.
+	tagName   = "Name"
+	tagPrefix = "docker-volume-efs:"
+)
+
+// Helper function to build the CreateFileSystem input for a volume.
+func FilesystemInput(n string, o Options) (*createFileSystemInput, error) {
+	input := &createFileSystemInput{
+		CreationToken: aws.String(n),
+	}
+
+	if v := o.Get(optPerformanceMode, ""); v != "" {
+		if v != "generalPurpose" && v != "maxIO" {
+			return nil, fmt.Errorf("invalid %s: %s (generalPurpose, maxIO)", optPerformanceMode, v)
+		}
+		input.PerformanceMode = aws.String(v)
+	}
+
+	if v := o.Get(optThroughputMode, ""); v != "" {
+		if v != "bursting" && v != "provisioned" {
+			return nil, fmt.Errorf("invalid %s: %s (bursting, provisioned)", optThroughputMode, v)
+		}
+		input.ThroughputMode = aws.String(v)
+	}
+
+	if v := o.Get(optProvisionedThroughput, ""); v != "" {
+		mibps, err := strconv.ParseFloat(v, 64)
+		if err != nil || mibps <= 0 {
+			return nil, fmt.Errorf("invalid %s: %s (MiB/s)", optProvisionedThroughput, v)
+		}
+		input.ThroughputMode = aws.String("provisioned")
+		input.ProvisionedThroughputInMibps = aws.Float64(mibps)
+	}
+	if aws.StringValue(input.ThroughputMode) == "provisioned" && input.ProvisionedThroughputInMibps == nil {
+		return nil, fmt.Errorf("%s is required when %s is provisioned", optProvisionedThroughput, optThroughputMode)
+	}
+
+	encrypted, err := o.Bool(optEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	if v := o.Get(optKmsKeyId, ""); v != "" {
+		encrypted = true
+		input.KmsKeyId = aws.String(v)
+	}
+	if encrypted {
+		input.Encrypted = aws.Bool(true)
+	}
+
+	return input, nil
+}
+
+// Helper function to build the lifecycle policies for a volume, if any.
+func LifecycleInput(id string, o Options) *putLifecycleConfigurationInput {
+	v := o.Get(optTransitionToIA, "")
+	if v == "" {
+		return nil
+	}
+
+	// Allow "30" as a shorthand for "AFTER_30_DAYS".
+	if _, err := strconv.Atoi(v); err == nil {
+		v = fmt.Sprintf("AFTER_%s_DAYS", v)
+	}
+
+	return &putLifecycleConfigurationInput{
+		FileSystemId: aws.String(id),
+		LifecyclePolicies: []*lifecyclePolicy{
+			{TransitionToIA: aws.String(strings.ToUpper(v))},
+		},
+	}
+}
+
+// Helper function to convert the options given when a volume was created
+// into tags. Tag values can't contain commas, so lists are stored space
+// separated. Credentials are never recorded.
+func OptionTags(o Options) []*efs.Tag {
+	keys := make([]string, 0, len(o))
+	for k := range o {
+		if !Contains(credentialOptions, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var tags []*efs.Tag
+	for _, k := range keys {
+		tags = append(tags, &efs.Tag{
+			Key:   aws.String(tagPrefix + k),
+			Value: aws.String(strings.Replace(o[k], ",", " ", -1)),
+		})
+	}
+	return tags
+}
+
+// Helper function to convert tags back into the options given when a volume
+// was created. Tags can't choose the credentials a volume is used with.
+func TagOptions(tags []*efs.Tag) Options {
+	o := make(Options)
+	for _, t := range tags {
+		k := aws.StringValue(t.Key)
+		if !strings.HasPrefix(k, tagPrefix) || k == tagVolume || k == tagNamespace {
+			continue
+		}
+		if k = strings.TrimPrefix(k, tagPrefix); !Contains(credentialOptions, k) {
+			o[k] = strings.Replace(aws.StringValue(t.Value), " ", ",", -1)
+		}
+	}
+	return o
+}
+
+// Helper function to check that the options given when creating a volume
+// which already exists are the ones it was created with.
+func ConflictingOptions(n string, existing, explicit Options) error {
+	keys := make([]string, 0, len(explicit))
+	for k := range explicit {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v, ok := existing[k]; !ok {
+			return fmt.Errorf("volume %s already exists without %s", n, k)
+		} else if v != explicit[k] {
+			return fmt.Errorf("volume %s already exists with %s=%s", n, k, v)
+		}
+	}
+	return nil
+}
+
+// Helper function to determine if a volume is mounted with the
+// amazon-efs-utils mount helper instead of plain NFS.
+func UsesMountHelper(o Options) bool {
+	tls, _ := o.Bool(optTLS)
+	iam, _ := o.Bool(optIAM)
+	return tls || iam
+}
+
+// Helper function to build the arguments for mounting a volume from an
+// address (see MountAddress).
+func MountArgs(m *efs.MountTargetDescription, addr, zone, p string, o Options) []string {
+	var options []string
+	if v := o.Get(optMountOptions, ""); v != "" {
+		options = append(options, v)
+	}
+
+	if !UsesMountHelper(o) {
+		args := []string{"-t", "nfs4"}
+		if len(options) > 0 {
+			args = append(args, "-o", strings.Join(options, ","))
+		}
+		return append(args, addr+":/", p)
+	}
+
+	// IAM authorization requires TLS.
+	options = append(options, optTLS)
+	if iam, _ := o.Bool(optIAM); iam {
+		options = append(options, optIAM)
+	}
+	// The mount helper resolves DNS names itself.
+	switch {
+	case addr == *m.IpAddress:
+		options = append(options, "mounttargetip="+addr)
+	case zone != "":
+		options = append(options, "az="+zone)
+	}
+
+	return []string{"-t", "efs", "-o", strings.Join(options, ","), *m.FileSystemId + ":/", p}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+func TestConflictingOptions(t *testing.T) {
+	existing := Options{optOwner: "team-a", "performance_mode": "maxIO", "subnets": "subnet-1,subnet-2"}
+
+	tests := []struct {
+		name     string
+		explicit Options
+		conflict bool
+	}{
+		{"no options", Options{}, false},
+		{"same options", Options{optOwner: "team-a", "subnets": "subnet-1,subnet-2"}, false},
+		{"another owner", Options{optOwner: "team-b"}, true},
+		{"an option it was created without", Options{"quota": "10GiB"}, true},
+		{"another list", Options{"subnets": "subnet-1"}, true},
+	}
+	for _, tt := range tests {
+		err := ConflictingOptions("foo", existing, tt.explicit)
+		if tt.conflict != (err != nil) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestOptionTagsRoundTrip(t *testing.T) {
+	o := Options{optOwner: "team-a", "subnets": "subnet-1,subnet-2"}
+	got := TagOptions(OptionTags(o))
+	if len(got) != len(o) {
+		t.Fatalf("got %v, want %v", got, o)
+	}
+	for k, v := range o {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+	if err := ConflictingOptions("foo", got, o); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptionTagsWithoutCredentials(t *testing.T) {
+	o := Options{optOwner: "team-a", optRoleArn: "arn:aws:iam::123456789012:role/efs", optExternalId: "secret"}
+	for _, tag := range OptionTags(o) {
+		if k := *tag.Key; k == tagPrefix+optRoleArn || k == tagPrefix+optExternalId {
+			t.Errorf("%s recorded as a tag", k)
+		}
+	}
+
+	tags := OptionTags(Options{optOwner: "team-a"})
+	for _, k := range credentialOptions {
+		v := "x"
+		tags = append(tags, &efs.Tag{Key: aws.String(tagPrefix + k), Value: &v})
+	}
+	tags = append(tags, &efs.Tag{Key: aws.String(tagVolume), Value: aws.String("foo")})
+	got := TagOptions(tags)
+	if len(got) != 1 || got[optOwner] != "team-a" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestMountArgs(t *testing.T) {
+	m := &efs.MountTargetDescription{
+		FileSystemId: aws.String("fs-12345678"),
+		IpAddress:    aws.String("10.0.0.10"),
+	}
+	dns := "us-east-1a.fs-12345678.efs.us-east-1.amazonaws.com"
+
+	tests := []struct {
+		name string
+		addr string
+		zone string
+		o    Options
+		want []string
+	}{
+		{
+			name: "nfs",
+			addr: "10.0.0.10",
+			want: []string{"-t", "nfs4", "10.0.0.10:/", "/mnt/efs/foo"},
+		},
+		{
+			name: "nfs with mount options",
+			addr: dns,
+			o:    Options{optMountOptions: "nfsvers=4.1,hard"},
+			want: []string{"-t", "nfs4", "-o", "nfsvers=4.1,hard", dns + ":/", "/mnt/efs/foo"},
+		},
+		{
+			name: "tls by ip",
+			addr: "10.0.0.10",
+			o:    Options{optTLS: "true"},
+			want: []string{"-t", "efs", "-o", "tls,mounttargetip=10.0.0.10", "fs-12345678:/", "/mnt/efs/foo"},
+		},
+		{
+			name: "iam by dns in a zone",
+			addr: dns,
+			zone: "us-east-1a",
+			o:    Options{optIAM: "true", optMountOptions: "ro"},
+			want: []string{"-t", "efs", "-o", "ro,tls,iam,az=us-east-1a", "fs-12345678:/", "/mnt/efs/foo"},
+		},
+		{
+			name: "tls by regional dns",
+			addr: "fs-12345678.efs.us-east-1.amazonaws.com",
+			o:    Options{optTLS: "true", optIAM: "false"},
+			want: []string{"-t", "efs", "-o", "tls", "fs-12345678:/", "/mnt/efs/foo"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := MountArgs(m, tt.addr, tt.zone, "/mnt/efs/foo", tt.o)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
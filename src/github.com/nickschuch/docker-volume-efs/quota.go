@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// EFS has no quotas, so they are enforced (softly) by the plugin: each volume
+// is a whole EFS Filesystem, and its usage is the size EFS meters for it
+// (SizeInBytes), which EFS updates every hour or so. Volumes can't be stopped
+// from going over their quota, only noticed when they have.
+
+const (
+	// Soft limit on the size of a volume (eg. 100GiB).
+	optQuota = "quota"
+
+	// What happens when a volume is over its quota: log (and metrics), refuse
+	// (new mounts) or readonly (remount it read-only until it is back under).
+	optQuotaAction = "quota_action"
+
+	quotaLog      = "log"
+	quotaRefuse   = "refuse"
+	quotaReadOnly = "readonly"
+)
+
+var (
+	cliQuotaInterval = kingpin.Flag("quota-interval", "How often to check the usage of each mounted volume.").Default("5m").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_QUOTA_INTERVAL").Duration()
+
+	// Units of sizes, both binary (GiB) and decimal (GB).
+	sizeUnits = map[string]int64{
+		"":    1,
+		"B":   1,
+		"KiB": 1 << 10,
+		"MiB": 1 << 20,
+		"GiB": 1 << 30,
+		"TiB": 1 << 40,
+		"PiB": 1 << 50,
+		"KB":  1e3,
+		"MB":  1e6,
+		"GB":  1e9,
+		"TB":  1e12,
+		"PB":  1e15,
+	}
+)
+
+// VolumeUsage is the result of the last usage check of a volume.
+type VolumeUsage struct {
+	Bytes    int64
+	Quota    int64
+	Action   string
+	Exceeded bool
+
+	// The volume is mounted read-only on purpose (mount_options).
+	readOnly bool
+}
+
+// Helper function to parse a size, eg. 100GiB or 1.5TB.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size: %s (unknown unit)", s)
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	// Converting a float which doesn't fit wraps around (eg. to a negative
+	// size). MaxInt64 itself rounds up to 2^63 as a float.
+	size := v * float64(unit)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size: %s (too large)", s)
+	}
+	return int64(size), nil
+}
+
+// Helper function to get the quota of a volume and what happens when it is
+// exceeded. The quota is 0 if the volume has none.
+func Quota(o Options) (int64, string, error) {
+	action := o.Get(optQuotaAction, quotaLog)
+	switch action {
+	case quotaLog, quotaRefuse, quotaReadOnly:
+	default:
+		return 0, "", fmt.Errorf("invalid %s: %s (%s, %s, %s)", optQuotaAction, action, quotaLog, quotaRefuse, quotaReadOnly)
+	}
+
+	v := o.Get(optQuota, "")
+	if v == "" {
+		return 0, action, nil
+	}
+	limit, err := ParseSize(v)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid %s: %s", optQuota, err)
+	}
+	return limit, action, nil
+}
+
+// Helper function to get the metered size of an EFS Filesystem.
+func Usage(fs *efs.FileSystemDescription) int64 {
+	if fs.SizeInBytes == nil || fs.SizeInBytes.Value == nil {
+		return 0
+	}
+	return *fs.SizeInBytes.Value
+}
+
+// QuotaChecker regularly checks the usage of the mounted volumes which have a
+// quota.
+type QuotaChecker struct {
+	Driver   DriverEFS
+	Interval time.Duration
+
+	// Volumes with usage metrics, so they can be removed once unmounted, and
+	// the metered size of each volume when it was last checked.
+	mu       sync.Mutex
+	reported map[string]bool
+	sizes    map[string]int64
+}
+
+// Helper function to create a quota checker for the volumes of a driver.
+func NewQuotaChecker(d DriverEFS, interval time.Duration) *QuotaChecker {
+	return &QuotaChecker{
+		Driver:   d,
+		Interval: interval,
+		reported: make(map[string]bool),
+		sizes:    make(map[string]int64),
+	}
+}
+
+// Start checks all volumes every interval. This does not return.
+func (c *QuotaChecker) Start() {
+	for {
+		c.CheckAll()
+		time.Sleep(c.Interval)
+	}
+}
+
+// CheckAll checks every volume which is currently mounted.
+func (c *QuotaChecker) CheckAll() {
+	l := log.WithField("task", "quota")
+
+	volumes, err := MountedVolumes(c.Driver.Root)
+	if err != nil {
+		l.WithField("error", err).Error("Cannot read the mount table")
+		return
+	}
+
+	for n, info := range volumes {
+		vl := l.WithField("volume", n)
+		u, err := c.Check(vl, n)
+		if err != nil {
+			vl.WithField("error", err).Warn("Cannot check usage")
+			continue
+		}
+		if u != nil {
+			c.Enforce(vl, n, HasOption(info.Opts, "ro") || HasOption(info.VfsOpts, "ro"), u)
+		}
+	}
+
+	// Forget about volumes which are no longer mounted.
+	c.mu.Lock()
+	for n := range c.reported {
+		if _, ok := volumes[n]; !ok {
+			metricUsage.Delete(n)
+			metricQuota.Delete(n)
+			delete(c.reported, n)
+			delete(c.sizes, n)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Check gets the usage of a volume and records it. Returns nil if the volume
+// doesn't exist.
+func (c *QuotaChecker) Check(l *log.Entry, n string) (*VolumeUsage, error) {
+	_, fs, o, err := c.Driver.Lookup(l, n)
+	if err != nil || fs == nil {
+		return nil, err
+	}
+	limit, action, err := Quota(o)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &VolumeUsage{
+		Bytes:    Usage(fs),
+		Quota:    limit,
+		Action:   action,
+		Exceeded: limit > 0 && Usage(fs) > limit,
+		readOnly: HasOption(o.Get(optMountOptions, ""), "ro"),
+	}
+
+	c.mu.Lock()
+	c.reported[n] = true
+	c.sizes[n] = u.Bytes
+	metricUsage.Set(float64(u.Bytes), n)
+	if limit > 0 {
+		metricQuota.Set(float64(limit), n)
+	} else {
+		metricQuota.Delete(n)
+	}
+	c.mu.Unlock()
+
+	if u.Exceeded {
+		metricQuotaExceeded.Inc(action)
+		l.WithFields(log.Fields{
+			"usage":  u.Bytes,
+			"quota":  u.Quota,
+			"action": u.Action,
+		}).Warn("Volume is over its quota")
+	}
+	return u, nil
+}
+
+// Cached gets the usage of a volume without calling the EFS API, from its
+// last check or its cached description. Returns nil if neither is known (eg.
+// the volume isn't mounted on this host yet), the volume is checked once it
+// is.
+func (c *QuotaChecker) Cached(n string, o Options) (*VolumeUsage, error) {
+	limit, action, err := Quota(o)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	size, ok := c.sizes[n]
+	c.mu.Unlock()
+	if !ok {
+		s, cached := c.Driver.Cache.Status(n)
+		if !cached {
+			return nil, nil
+		}
+		size = s.Usage
+	}
+
+	return &VolumeUsage{
+		Bytes:    size,
+		Quota:    limit,
+		Action:   action,
+		Exceeded: limit > 0 && size > limit,
+		readOnly: HasOption(o.Get(optMountOptions, ""), "ro"),
+	}, nil
+}
+
+// Enforce remounts a mounted volume read-only while it is over its quota (if
+// its quota action is readonly), and read-write again once it is back under.
+func (c *QuotaChecker) Enforce(l *log.Entry, n string, readOnly bool, u *VolumeUsage) {
+	if u.Action != quotaReadOnly || u.Exceeded == readOnly {
+		return
+	}
+
+	// Volumes mounted read-only on purpose stay that way.
+	if !u.Exceeded && u.readOnly {
+		return
+	}
+
+	mode := "rw"
+	if u.Exceeded {
+		mode = "ro"
+	}
+	p := filepath.Join(c.Driver.Root, n)
+	if err := Exec("mount", "-o", "remount,"+mode, p); err != nil {
+		l.WithField("error", err).Error("Cannot remount")
+		return
+	}
+	l.WithField("mode", mode).Warn("Remounted for quota")
+}
+
+// Helper function to determine if a comma separated list of mount options
+// includes an option.
+func HasOption(options, option string) bool {
+	return Contains(strings.Split(options, ","), option)
+}
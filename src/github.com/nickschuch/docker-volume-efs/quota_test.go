@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "512B", want: 512},
+		{in: "1KiB", want: 1 << 10},
+		{in: "100GiB", want: 100 << 30},
+		{in: "1.5TiB", want: 3 << 39},
+		{in: "2TB", want: 2e12},
+		{in: "1PB", want: 1e15},
+		{in: " 10 MiB ", want: 10 << 20},
+		{in: "1.5", want: 1},
+		{in: "", err: true},
+		{in: "GiB", err: true},
+		{in: "10gib", err: true},
+		{in: "10XB", err: true},
+		{in: "1.2.3GB", err: true},
+		{in: "-1GiB", err: true},
+		{in: "8191PiB", want: 8191 << 50},
+		{in: "8192PiB", err: true},
+		{in: "99999999PB", err: true},
+		{in: "9223372036854775808", err: true},
+		{in: "9999999E", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	tests := []struct {
+		o      Options
+		limit  int64
+		action string
+		err    bool
+	}{
+		{o: Options{}, action: quotaLog},
+		{o: Options{optQuota: "1GiB"}, limit: 1 << 30, action: quotaLog},
+		{o: Options{optQuota: "1GiB", optQuotaAction: quotaReadOnly}, limit: 1 << 30, action: quotaReadOnly},
+		{o: Options{optQuotaAction: quotaRefuse}, action: quotaRefuse},
+		{o: Options{optQuota: "lots"}, err: true},
+		{o: Options{optQuota: "1GiB", optQuotaAction: "delete"}, err: true},
+	}
+	for _, tt := range tests {
+		limit, action, err := Quota(tt.o)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Quota(%v) = %d, %s, want an error", tt.o, limit, action)
+			}
+			continue
+		}
+		if err != nil || limit != tt.limit || action != tt.action {
+			t.Errorf("Quota(%v) = %d, %s, %v, want %d, %s", tt.o, limit, action, err, tt.limit, tt.action)
+		}
+	}
+}
+
+func TestQuotaCached(t *testing.T) {
+	o := Options{optQuota: "1GiB", optQuotaAction: quotaRefuse}
+
+	tests := []struct {
+		name     string
+		status   *VolumeStatus
+		size     *int64
+		want     *int64
+		exceeded bool
+	}{
+		{
+			name: "unknown",
+		},
+		{
+			name:     "described over its quota",
+			status:   &VolumeStatus{Usage: 2 << 30},
+			want:     size(2 << 30),
+			exceeded: true,
+		},
+		{
+			name:   "described under its quota",
+			status: &VolumeStatus{Usage: 1 << 20},
+			want:   size(1 << 20),
+		},
+		{
+			name:   "checked since it was described",
+			status: &VolumeStatus{Usage: 2 << 30},
+			size:   size(1 << 20),
+			want:   size(1 << 20),
+		},
+	}
+
+	for _, tt := range tests {
+		d := DriverEFS{Cache: NewMountCache(time.Minute)}
+		c := NewQuotaChecker(d, time.Minute)
+		if tt.status != nil {
+			d.Cache.PutStatus("foo", tt.status)
+		}
+		if tt.size != nil {
+			c.sizes["foo"] = *tt.size
+		}
+
+		u, err := c.Cached("foo", o)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if tt.want == nil {
+			if u != nil {
+				t.Errorf("%s: got %d bytes, want nothing", tt.name, u.Bytes)
+			}
+			continue
+		}
+		if u == nil {
+			t.Errorf("%s: got nothing, want %d bytes", tt.name, *tt.want)
+			continue
+		}
+		if u.Bytes != *tt.want || u.Exceeded != tt.exceeded || u.Action != quotaRefuse {
+			t.Errorf("%s: got %+v, want %d bytes (exceeded %t)", tt.name, u, *tt.want, tt.exceeded)
+		}
+	}
+}
+
+func size(b int64) *int64 {
+	return &b
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/mount"
+)
+
+const (
+	staleHealth = "health"
+	staleSource = "source"
+)
+
+// Helper function to determine if an existing mount is dead and needs to be
+// recovered. Returns the reason, or an empty string if the mount is fine.
+// A mount is dead if statfs reports ESTALE or hangs, or if it was mounted
+// from an address which is no longer the mount target for the filesystem
+// (eg. the filesystem or mount target was deleted and recreated).
+func StaleReason(hc *HealthChecker, name string, info *mount.Info, target string) string {
+	if hc != nil {
+		if h := hc.Check(name); h.State == healthStale {
+			return staleHealth
+		}
+	}
+
+	if target != "" && MountSource(info) != target {
+		return staleSource
+	}
+
+	return ""
+}
+
+// Helper function to get the address a volume was mounted from.
+func MountSource(info *mount.Info) string {
+	return strings.SplitN(info.Source, ":", 2)[0]
+}
+
+// Helper function to detach a dead mount. A lazy unmount (MNT_DETACH) is used
+// because a regular unmount blocks on a hung NFS server.
+func DetachMount(l *log.Entry, p string) error {
+	if err := Exec("umount", "-l", p); err != nil {
+		l.WithField("error", err).Error("Cannot detach stale mount")
+		return err
+	}
+	l.Info("Detached stale mount")
+	return nil
+}
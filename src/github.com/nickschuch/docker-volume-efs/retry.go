@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/service"
+)
+
+const (
+	// Bounds of the delay between retries of an AWS API call.
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 20 * time.Second
+)
+
+var (
+	cliAWSMaxRetries = kingpin.Flag("aws-max-retries", "How many times to retry an AWS API call which was throttled or failed with a server error.").Default("8").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_AWS_MAX_RETRIES").Uint64()
+	cliAWSRate       = kingpin.Flag("aws-rate", "Maximum AWS API calls per second made by this host (0 is unlimited).").Default("10").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_AWS_RATE").Float()
+	cliAWSBurst      = kingpin.Flag("aws-burst", "Maximum AWS API calls made at once before --aws-rate applies.").Default("20").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_AWS_BURST").Int()
+
+	// Shared by every AWS client so the host as a whole stays under the rate.
+	awsLimiter     *TokenBucket
+	awsLimiterOnce sync.Once
+
+	// The codes AWS uses when an API call was throttled.
+	throttleCodes = map[string]bool{
+		"Throttling":               true,
+		"ThrottlingException":      true,
+		"RequestLimitExceeded":     true,
+		"RequestThrottled":         true,
+		"TooManyRequestsException": true,
+	}
+)
+
+// TokenBucket limits how often something happens, while allowing bursts.
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// The clock, which tests replace.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// Helper function to create a full token bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until a token is available and takes it. Returns how long it
+// waited.
+func (b *TokenBucket) Wait() time.Duration {
+	if b.Rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.Rate
+	if b.tokens > b.Burst {
+		b.tokens = b.Burst
+	}
+	b.last = now
+
+	// Take the token now (going into debt if needed) so waiters are served in
+	// order, then sleep until it would have been available.
+	b.tokens--
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.Rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	b.sleep(wait)
+	return wait
+}
+
+// Retryer retries throttled and failed (5xx) AWS API calls with decorrelated
+// jitter, so hosts which are throttled at the same time (eg. at boot) spread
+// their retries out instead of retrying in lockstep.
+type Retryer struct{}
+
+// MaxRetries returns how many times an API call is retried.
+func (Retryer) MaxRetries() uint {
+	return uint(*cliAWSMaxRetries)
+}
+
+// ShouldRetry returns true if an API call was throttled or failed with a
+// server error.
+func (Retryer) ShouldRetry(r *request.Request) bool {
+	if r.HTTPResponse != nil && r.HTTPResponse.StatusCode >= 500 {
+		return true
+	}
+	return r.IsErrorRetryable() || Throttled(r.Error)
+}
+
+// RetryRules returns how long to wait before retrying an API call: a random
+// delay between the base delay and three times the previous delay.
+func (Retryer) RetryRules(r *request.Request) time.Duration {
+	prev := r.RetryDelay
+	if prev < retryBaseDelay {
+		prev = retryBaseDelay
+	}
+
+	delay := retryBaseDelay + time.Duration(rand.Int63n(int64(prev*3-retryBaseDelay)+1))
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// Helper function to determine if an API call was throttled.
+func Throttled(err error) bool {
+	if err, ok := err.(awserr.Error); ok {
+		return throttleCodes[err.Code()]
+	}
+	return false
+}
+
+// Helper function to apply the retry policy and the shared rate limit to a
+// service client.
+func LimitRequests(s *service.Service) {
+	awsLimiterOnce.Do(func() {
+		awsLimiter = NewTokenBucket(*cliAWSRate, *cliAWSBurst)
+	})
+
+	s.Retryer = Retryer{}
+
+	// Every attempt (including retries) waits for the rate limit.
+	s.Handlers.Send.PushFront(func(r *request.Request) {
+		if wait := awsLimiter.Wait(); wait > 0 {
+			metricAWSRateLimited.Observe(wait.Seconds(), r.Service.ServiceName)
+		}
+	})
+	s.Handlers.Retry.PushBack(func(r *request.Request) {
+		if Throttled(r.Error) {
+			metricAWSThrottles.Inc(r.Service.ServiceName, r.Operation.Name)
+		}
+	})
+}
+
+// Helper function to determine if an API call failed with an error code.
+func IsErrorCode(err error, code string) bool {
+	if err, ok := err.(awserr.Error); ok {
+		return err.Code() == code
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		calls int
+		// Milliseconds between the calls, and how long each waits.
+		every time.Duration
+		want  []time.Duration
+	}{
+		{name: "unlimited", rate: 0, burst: 1, calls: 4, want: []time.Duration{0, 0, 0, 0}},
+		{name: "within the burst", rate: 100, burst: 5, calls: 5, want: []time.Duration{0, 0, 0, 0, 0}},
+		{name: "past the burst", rate: 100, burst: 2, calls: 6, want: []time.Duration{0, 0, 10, 10, 10, 10}},
+		{name: "no burst", rate: 100, burst: 0, calls: 3, want: []time.Duration{0, 10, 10}},
+		{name: "refilled", rate: 100, burst: 2, calls: 5, every: 5, want: []time.Duration{0, 0, 0, 5, 5}},
+		{name: "refilled to the burst", rate: 100, burst: 2, calls: 4, every: 100, want: []time.Duration{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		// The clock only moves when the calls sleep, or between them.
+		clock := time.Unix(0, 0)
+		b := NewTokenBucket(tt.rate, tt.burst)
+		b.last = clock
+		b.now = func() time.Time { return clock }
+		b.sleep = func(d time.Duration) { clock = clock.Add(d) }
+
+		var got []time.Duration
+		for i := 0; i < tt.calls; i++ {
+			got = append(got, b.Wait().Round(time.Microsecond)/time.Millisecond)
+			clock = clock.Add(tt.every * time.Millisecond)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: waited %v ms, want %v ms", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryRules(t *testing.T) {
+	tests := []struct {
+		prev time.Duration
+		max  time.Duration
+	}{
+		{prev: 0, max: 3 * retryBaseDelay},
+		{prev: time.Second, max: 3 * time.Second},
+		{prev: time.Minute, max: retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := Retryer{}.RetryRules(&request.Request{RetryDelay: tt.prev})
+			if d < retryBaseDelay || d > tt.max {
+				t.Errorf("after %s: got %s, want between %s and %s", tt.prev, d, retryBaseDelay, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("ThrottlingException", "Rate exceeded", nil), true},
+		{awserr.New("RequestLimitExceeded", "Request limit exceeded", nil), true},
+		{awserr.New("FileSystemNotFound", "Not found", nil), false},
+		{errors.New("ThrottlingException"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := Throttled(tt.err); got != tt.want {
+			t.Errorf("Throttled(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
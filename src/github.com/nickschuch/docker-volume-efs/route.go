@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+const (
+	// EFS is only served over NFS.
+	nfsPort = "2049"
+)
+
+var (
+	cliRouteTimeout = kingpin.Flag("route-timeout", "How long to wait when checking this host can reach a mount target before mounting it.").Default("3s").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_ROUTE_TIMEOUT").Duration()
+)
+
+// Helper function to check that this host can reach a mount target (eg. it
+// is in a peered VPC or another region). Mounting an unreachable target
+// would otherwise hang until the mount times out.
+func CheckRoute(m *efs.MountTargetDescription, timeout time.Duration) error {
+	addr := net.JoinHostPort(aws.StringValue(m.IpAddress), nfsPort)
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("cannot reach mount target %s (%s) of %s: %s", aws.StringValue(m.MountTargetId), aws.StringValue(m.SubnetId), aws.StringValue(m.FileSystemId), err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/service"
+	"github.com/aws/aws-sdk-go/aws/service/serviceinfo"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// The vendored SDK has no S3 client, and its Signature Version 4 signer is
+// internal to it. Signature Version 4 signs any service by its name though, so
+// this small client borrows the signing handlers of an EFS client and talks to
+// S3 (or anything compatible with it, eg. MinIO) with path style URLs.
+
+// S3 is a client for a bucket in an S3 compatible object store.
+type S3 struct {
+	*service.Service
+	Bucket string
+}
+
+// S3Object is an object in a bucket listing.
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type listBucketResult struct {
+	Contents              []S3Object
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// Helper function to create an S3 client. The endpoint defaults to AWS S3 in
+// the region, and the credentials to the default chain (eg. the instance
+// role) when no keys are given.
+func NewS3(endpoint, region, bucket, accessKey, secretKey string, l *log.Entry) *S3 {
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	c := &aws.Config{
+		Region:   aws.String(region),
+		Endpoint: aws.String(strings.TrimRight(endpoint, "/")),
+	}
+	if accessKey != "" {
+		c.Credentials = credentials.NewStaticCredentials(accessKey, secretKey, "")
+	}
+	c = defaults.DefaultConfig.Merge(c)
+
+	s := &service.Service{
+		ServiceInfo: serviceinfo.ServiceInfo{
+			Config:      c,
+			ServiceName: "s3",
+		},
+	}
+	s.Initialize()
+
+	s.Handlers.Sign = efs.New(c).Handlers.Copy().Sign
+	s.Handlers.UnmarshalMeta.PushBack(s3UnmarshalMeta)
+	s.Handlers.Unmarshal.PushBack(s3Unmarshal)
+	s.Handlers.UnmarshalError.PushBack(s3UnmarshalError)
+	LimitRequests(s)
+	InstrumentRequests(s, l)
+
+	return &S3{Service: s, Bucket: bucket}
+}
+
+// Helper function to create a request for an object (or the bucket when the
+// key is empty).
+func (s *S3) newRequest(name, method, key string, query url.Values, data interface{}) *request.Request {
+	p := "/" + s.Bucket
+	if key != "" {
+		p += "/" + key
+	}
+	if len(query) > 0 {
+		p += "?" + query.Encode()
+	}
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: method,
+		HTTPPath:   p,
+	}
+	return s.NewRequest(op, nil, data)
+}
+
+// Put uploads an object.
+func (s *S3) Put(key string, b []byte) error {
+	r := s.newRequest("PutObject", "PUT", key, nil, nil)
+	r.SetBufferBody(b)
+	return r.Send()
+}
+
+// Get downloads an object.
+func (s *S3) Get(key string) ([]byte, error) {
+	var b []byte
+	r := s.newRequest("GetObject", "GET", key, nil, &b)
+	return b, r.Send()
+}
+
+// Exists determines if an object exists.
+func (s *S3) Exists(key string) (bool, error) {
+	r := s.newRequest("HeadObject", "HEAD", key, nil, nil)
+	err := r.Send()
+	if IsErrorCode(err, "NotFound") || IsErrorCode(err, "NoSuchKey") {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete deletes an object.
+func (s *S3) Delete(key string) error {
+	return s.newRequest("DeleteObject", "DELETE", key, nil, nil).Send()
+}
+
+// List lists the objects with a prefix.
+func (s *S3) List(prefix string) ([]S3Object, error) {
+	var objects []S3Object
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		var result listBucketResult
+		if err := s.newRequest("ListObjectsV2", "GET", "", q, &result).Send(); err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func s3UnmarshalMeta(r *request.Request) {
+	r.RequestID = r.HTTPResponse.Header.Get("X-Amz-Request-Id")
+}
+
+func s3Unmarshal(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+
+	switch data := r.Data.(type) {
+	case *[]byte:
+		b, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		if err != nil {
+			r.Error = awserr.New("SerializationError", "failed to read response body", err)
+			return
+		}
+		*data = b
+	case *listBucketResult:
+		if err := xml.NewDecoder(r.HTTPResponse.Body).Decode(data); err != nil {
+			r.Error = awserr.New("SerializationError", "failed to decode bucket listing", err)
+		}
+	}
+}
+
+func s3UnmarshalError(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+
+	// Responses to HEAD requests have no body, so the status is the code.
+	e := s3Error{
+		Code:    strings.Replace(http.StatusText(r.HTTPResponse.StatusCode), " ", "", -1),
+		Message: r.HTTPResponse.Status,
+	}
+	if b, err := ioutil.ReadAll(r.HTTPResponse.Body); err == nil && len(b) > 0 {
+		xml.Unmarshal(b, &e)
+	}
+	r.Error = awserr.NewRequestFailure(awserr.New(e.Code, e.Message, nil), r.HTTPResponse.StatusCode, r.RequestID)
+}
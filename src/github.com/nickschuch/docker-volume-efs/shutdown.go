@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+	"github.com/jasonlvhit/gocron"
+)
+
+var (
+	cliShutdownTimeout = kingpin.Flag("shutdown-timeout", "How long to wait for in-flight requests to finish on shutdown.").Default("30s").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_SHUTDOWN_TIMEOUT").Duration()
+	cliUnmountOnExit   = kingpin.Flag("unmount-on-exit", "Unmount volumes which are not used by a container on shutdown.").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_UNMOUNT_ON_EXIT").Bool()
+)
+
+// Scheduler runs scheduled tasks until it is stopped. The gocron Start()
+// function spins forever and cannot be stopped, so we drive RunPending()
+// ourselves.
+type Scheduler struct {
+	*gocron.Scheduler
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Helper function to create a task scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		Scheduler: gocron.NewScheduler(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs pending tasks every second until Stop is called.
+func (s *Scheduler) Start() {
+	defer close(s.done)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.RunPending()
+		}
+	}
+}
+
+// Stop waits for a running task to finish and removes all tasks.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+	s.Clear()
+}
+
+// Helper function to serve plugin requests until a SIGINT or SIGTERM is
+// received. A SIGHUP calls reload instead.
+func Serve(h http.Handler, l net.Listener, reload func() error) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Info("Reloading configuration")
+				NotifyStatus("RELOADING=1")
+				if err := reload(); err != nil {
+					log.WithField("error", err).Error("Cannot reload configuration")
+				}
+				NotifyStatus("READY=1")
+			}
+		}
+	}()
+
+	srv := &http.Server{Handler: h}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting new requests and give the in-flight ones (eg. waiting for
+	// a new filesystem to become available) a chance to finish.
+	log.WithField("timeout", cliShutdownTimeout.String()).Info("Shutting down")
+	NotifyStatus("STOPPING=1\nSTATUS=Shutting down")
+
+	drain, cancelDrain := context.WithTimeout(context.Background(), *cliShutdownTimeout)
+	defer cancelDrain()
+
+	if err := srv.Shutdown(drain); err != nil {
+		log.WithField("error", err).Warn("In-flight requests did not finish")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+)
+
+// Snapshots are copies of a volume in a hidden directory, either on the same
+// EFS Filesystem or on another volume's. EFS has no snapshot API, so these
+// are only consistent if nothing writes to the volume while it is copied
+// (see --pause).
+
+const (
+	snapshotDir    = ".snapshots"
+	snapshotSuffix = ".json"
+	partialSuffix  = ".partial"
+)
+
+var (
+	cmdSnapshot = kingpin.Command("snapshot", "Manage volume snapshots.")
+
+	cmdSnapshotCreate        = cmdSnapshot.Command("create", "Copy a volume into a snapshot.")
+	cliSnapshotCreateVolume  = cmdSnapshotCreate.Arg("volume", "Volume to snapshot.").Required().String()
+	cliSnapshotCreateName    = cmdSnapshotCreate.Arg("name", "Name of the snapshot.").Required().String()
+	cliSnapshotCreateTarget  = cmdSnapshotCreate.Flag("target", "Volume to store the snapshot on (defaults to the volume itself).").String()
+	cliSnapshotCreatePause   = cmdSnapshotCreate.Flag("pause", "Pause the containers using the volume while it is copied.").Bool()
+	cliSnapshotCreateWorkers = cmdSnapshotCreate.Flag("workers", "How many files to copy at once.").Default("8").Int()
+
+	cmdSnapshotList       = cmdSnapshot.Command("list", "List the snapshots of a volume.")
+	cliSnapshotListVolume = cmdSnapshotList.Arg("volume", "Volume to list the snapshots of.").Required().String()
+	cliSnapshotListTarget = cmdSnapshotList.Flag("target", "Volume the snapshots are stored on (defaults to the volume itself).").String()
+
+	cmdSnapshotRestore        = cmdSnapshot.Command("restore", "Replace the contents of a volume with a snapshot.")
+	cliSnapshotRestoreVolume  = cmdSnapshotRestore.Arg("volume", "Volume to restore.").Required().String()
+	cliSnapshotRestoreName    = cmdSnapshotRestore.Arg("name", "Name of the snapshot.").Required().String()
+	cliSnapshotRestoreTarget  = cmdSnapshotRestore.Flag("target", "Volume the snapshot is stored on (defaults to the volume itself).").String()
+	cliSnapshotRestorePause   = cmdSnapshotRestore.Flag("pause", "Pause the containers using the volume while it is restored.").Bool()
+	cliSnapshotRestoreWorkers = cmdSnapshotRestore.Flag("workers", "How many files to copy at once.").Default("8").Int()
+	cliSnapshotRestoreForce   = cmdSnapshotRestore.Flag("force", "Confirm that the current contents of the volume will be deleted.").Bool()
+
+	cmdSnapshotDelete       = cmdSnapshot.Command("delete", "Delete a snapshot.")
+	cliSnapshotDeleteVolume = cmdSnapshotDelete.Arg("volume", "Volume the snapshot is of.").Required().String()
+	cliSnapshotDeleteName   = cmdSnapshotDelete.Arg("name", "Name of the snapshot.").Required().String()
+	cliSnapshotDeleteTarget = cmdSnapshotDelete.Flag("target", "Volume the snapshot is stored on (defaults to the volume itself).").String()
+)
+
+// Snapshot describes a snapshot. It is stored next to the snapshot's
+// directory.
+type Snapshot struct {
+	Volume   string
+	Name     string
+	Created  time.Time
+	Duration string
+	Files    int64
+	Bytes    int64
+}
+
+// Helper function to mount the volume a volume's snapshots are stored on,
+// and get the directory they are stored in.
+func (d DriverEFS) snapshots(l *log.Entry, volume, target string) (string, func(), error) {
+	if target == "" {
+		p, unmount, err := d.MountPrivate(l, volume)
+		if err != nil {
+			return "", nil, err
+		}
+		return filepath.Join(p, snapshotDir), unmount, nil
+	}
+
+	p, unmount, err := d.MountPrivate(l, target)
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(p, snapshotDir, volume), unmount, nil
+}
+
+// Helper function to log the progress of a copy.
+func copyProgress(l *log.Entry) func(CopyProgress) {
+	return func(p CopyProgress) {
+		percent := 100.0
+		if p.TotalBytes > 0 {
+			percent = float64(p.Bytes) / float64(p.TotalBytes) * 100
+		}
+		l.WithFields(log.Fields{
+			"files":   fmt.Sprintf("%d/%d", p.Files, p.TotalFiles),
+			"bytes":   fmt.Sprintf("%d/%d", p.Bytes, p.TotalBytes),
+			"percent": fmt.Sprintf("%.1f", percent),
+		}).Info("Copying")
+	}
+}
+
+// SnapshotCreate copies a volume into a snapshot.
+func SnapshotCreate(d DriverEFS) error {
+	volume, name := *cliSnapshotCreateVolume, *cliSnapshotCreateName
+	l := log.WithFields(log.Fields{
+		"volume":   volume,
+		"snapshot": name,
+	})
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	src, unmountSrc, err := d.MountPrivate(l, volume)
+	if err != nil {
+		return err
+	}
+	defer unmountSrc()
+
+	dir := filepath.Join(src, snapshotDir)
+	if *cliSnapshotCreateTarget != "" {
+		var unmount func()
+		dir, unmount, err = d.snapshots(l, volume, *cliSnapshotCreateTarget)
+		if err != nil {
+			return err
+		}
+		defer unmount()
+	}
+
+	dst := filepath.Join(dir, name)
+	if Exists(dst) {
+		return fmt.Errorf("snapshot %s of %s already exists", name, volume)
+	}
+
+	if *cliSnapshotCreatePause {
+		unpause, err := PauseContainers(volume)
+		if err != nil {
+			return err
+		}
+		defer unpause()
+	}
+
+	// Copy into a partial directory first, so a failed copy isn't mistaken
+	// for a snapshot.
+	start := time.Now()
+	partial := dst + partialSuffix
+	if err := os.RemoveAll(partial); err != nil {
+		return err
+	}
+	c := &Copier{
+		Workers:  *cliSnapshotCreateWorkers,
+		Exclude:  []string{snapshotDir},
+		Progress: copyProgress(l),
+		Interval: 5 * time.Second,
+	}
+	if err := c.Copy(src, partial); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return err
+	}
+
+	p := c.Current()
+	s := Snapshot{
+		Volume:   volume,
+		Name:     name,
+		Created:  start,
+		Duration: time.Since(start).String(),
+		Files:    p.Files,
+		Bytes:    p.Bytes,
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dst+snapshotSuffix, b, 0644); err != nil {
+		return err
+	}
+
+	l.WithField("duration", s.Duration).Info("Created snapshot")
+	return nil
+}
+
+// SnapshotList prints the snapshots of a volume.
+func SnapshotList(d DriverEFS) error {
+	l := log.WithField("volume", *cliSnapshotListVolume)
+
+	dir, unmount, err := d.snapshots(l, *cliSnapshotListVolume, *cliSnapshotListTarget)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCREATED\tFILES\tBYTES")
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), snapshotSuffix) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		var s Snapshot
+		if err := json.Unmarshal(b, &s); err != nil {
+			l.WithFields(log.Fields{
+				"file":  f.Name(),
+				"error": err,
+			}).Warn("Cannot read snapshot")
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", s.Name, s.Created.Format(time.RFC3339), s.Files, s.Bytes)
+	}
+	return w.Flush()
+}
+
+// SnapshotRestore replaces the contents of a volume with a snapshot.
+func SnapshotRestore(d DriverEFS) error {
+	volume, name := *cliSnapshotRestoreVolume, *cliSnapshotRestoreName
+	l := log.WithFields(log.Fields{
+		"volume":   volume,
+		"snapshot": name,
+	})
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if !*cliSnapshotRestoreForce {
+		return fmt.Errorf("restoring %s deletes its current contents, use --force to confirm", volume)
+	}
+
+	dst, unmountDst, err := d.MountPrivate(l, volume)
+	if err != nil {
+		return err
+	}
+	defer unmountDst()
+
+	dir := filepath.Join(dst, snapshotDir)
+	if *cliSnapshotRestoreTarget != "" {
+		var unmount func()
+		dir, unmount, err = d.snapshots(l, volume, *cliSnapshotRestoreTarget)
+		if err != nil {
+			return err
+		}
+		defer unmount()
+	}
+
+	src := filepath.Join(dir, name)
+	if !Exists(src + snapshotSuffix) {
+		return fmt.Errorf("snapshot %s of %s does not exist", name, volume)
+	}
+
+	if *cliSnapshotRestorePause {
+		unpause, err := PauseContainers(volume)
+		if err != nil {
+			return err
+		}
+		defer unpause()
+	}
+
+	// Delete everything except the snapshots.
+	files, err := ioutil.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Name() == snapshotDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dst, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	c := &Copier{
+		Workers:  *cliSnapshotRestoreWorkers,
+		Progress: copyProgress(l),
+		Interval: 5 * time.Second,
+	}
+	if err := c.Copy(src, dst); err != nil {
+		return err
+	}
+
+	l.WithField("duration", time.Since(start).String()).Info("Restored snapshot")
+	return nil
+}
+
+// SnapshotDelete deletes a snapshot.
+func SnapshotDelete(d DriverEFS) error {
+	volume, name := *cliSnapshotDeleteVolume, *cliSnapshotDeleteName
+	l := log.WithFields(log.Fields{
+		"volume":   volume,
+		"snapshot": name,
+	})
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	dir, unmount, err := d.snapshots(l, volume, *cliSnapshotDeleteTarget)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	p := filepath.Join(dir, name)
+	if !Exists(p + snapshotSuffix) {
+		return fmt.Errorf("snapshot %s of %s does not exist", name, volume)
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return err
+	}
+	if err := os.Remove(p + snapshotSuffix); err != nil {
+		return err
+	}
+
+	l.Info("Deleted snapshot")
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/alecthomas/kingpin"
+)
+
+// Under systemd the plugin can be socket activated (the socket unit creates
+// the plugin socket before dockerd starts, so dockerd never races us), and
+// tells systemd when it is ready and still alive (Type=notify, WatchdogSec=).
+// Both are small enough protocols that we don't need a library for them.
+
+const (
+	// The first file descriptor passed by systemd (SD_LISTEN_FDS_START).
+	listenFdsStart = 3
+)
+
+var (
+	cliWatchdogStall = kingpin.Flag("watchdog-stall", "How long a plugin API call (other than create, or waiting for EFS resources to be created) may run before the systemd watchdog is no longer notified.").Default("5m").OverrideDefaultFromEnvar("DOCKER_VOLUMES_EFS_WATCHDOG_STALL").Duration()
+)
+
+// Helper function to get the listener passed by systemd socket activation.
+// Returns nil if the plugin wasn't socket activated.
+func SocketActivation() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("expected 1 socket from systemd, got %d", n)
+	}
+
+	// Don't pass the socket on to mount and the other commands we run.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	syscall.CloseOnExec(listenFdsStart)
+
+	f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// A TCP socket has the same TLS requirements as --listen tcp://.
+	if l.Addr().Network() == "tcp" {
+		c, err := ServerTLSConfig(*cliTLSCert, *cliTLSKey, *cliTLSCA)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l = tls.NewListener(l, c)
+	}
+
+	return l, nil
+}
+
+// Helper function to send a notification (eg. READY=1) to systemd. Does
+// nothing if the plugin isn't run by systemd with Type=notify.
+func Notify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	// Abstract sockets are given with an @ instead of the leading NUL.
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+// Helper function to send a notification to systemd, logging a failure.
+func NotifyStatus(state string) {
+	if err := Notify(state); err != nil {
+		log.WithFields(log.Fields{
+			"state": state,
+			"error": err,
+		}).Warn("Cannot notify systemd")
+	}
+}
+
+// Helper function to get how often systemd expects a watchdog notification.
+// Returns 0 if the watchdog isn't enabled for this process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Helper function to notify the systemd watchdog while plugin API calls keep
+// finishing. A call stuck for longer than the stall limit (eg. on a dead NFS
+// server) stops the notifications, so systemd restarts the plugin. This does
+// not return.
+func Watchdog(i *InstrumentedDriver, interval, stall time.Duration) {
+	l := log.WithField("task", "watchdog")
+
+	// Notify twice per interval, so a late notification isn't a missed one.
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+
+	for range t.C {
+		if method, age := i.Oldest(); age > stall {
+			l.WithFields(log.Fields{
+				"method":   method,
+				"duration": age.String(),
+			}).Error("Plugin API call is stuck, not notifying the watchdog")
+			continue
+		}
+		NotifyStatus("WATCHDOG=1")
+	}
+}
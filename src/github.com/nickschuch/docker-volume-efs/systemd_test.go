@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSocketActivation(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name     string
+		pid, fds string
+		err      bool
+	}{
+		{name: "not socket activated"},
+		{name: "another process", pid: "1", fds: "1"},
+		{name: "invalid pid", pid: "plugin", fds: "1"},
+		{name: "no sockets", pid: pid},
+		{name: "zero sockets", pid: pid, fds: "0"},
+		{name: "invalid sockets", pid: pid, fds: "one"},
+		{name: "too many sockets", pid: pid, fds: "2", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			l, err := SocketActivation()
+			if l != nil {
+				l.Close()
+				t.Fatal("got a listener")
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %t", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSocketActivationInherited(t *testing.T) {
+	// Run again by the test itself (below), with the socket as fd 3.
+	// LISTEN_PID can only be set once that process is running.
+	if os.Getenv("TEST_SOCKET_ACTIVATION") != "" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		l, err := SocketActivation()
+		if err != nil || l == nil {
+			fmt.Fprintf(os.Stderr, "got %v, %v, want a listener\n", l, err)
+			os.Exit(1)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// The socket isn't passed on to the commands the plugin runs.
+		fmt.Fprintf(c, "%s %s", l.Addr(), os.Getenv("LISTEN_FDS"))
+		c.Close()
+		os.Exit(0)
+	}
+
+	addr := filepath.Join(t.TempDir(), "efs.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	f, err := l.File()
+	l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivationInherited$")
+	cmd.Env = append(os.Environ(), "TEST_SOCKET_ACTIVATION=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	// Only the socket activated process has the socket now.
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	c, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	got, err := ioutil.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("socket activated process: %s", err)
+	}
+	if want := addr + " "; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name      string
+		pid, usec string
+		want      time.Duration
+	}{
+		{name: "no watchdog"},
+		{name: "any process", usec: "30000000", want: 30 * time.Second},
+		{name: "this process", pid: pid, usec: "500000", want: 500 * time.Millisecond},
+		{name: "another process", pid: "1", usec: "30000000"},
+		{name: "invalid", usec: "30s"},
+		{name: "zero", usec: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+
+			if got := WatchdogInterval(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
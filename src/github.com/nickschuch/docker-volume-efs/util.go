@@ -1,7 +1,11 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/pkg/mount"
 )
 
 func Contains(s []string, e string) bool {
@@ -23,3 +27,38 @@ func Exists(path string) bool {
 	}
 	return true
 }
+
+// Helper function to get the volumes mounted directly under a directory,
+// keyed by volume name. This reads the mount table instead of stat'ing each
+// directory, which would block on a hung NFS server.
+func MountedVolumes(d string) (map[string]*mount.Info, error) {
+	mounts, err := mount.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]*mount.Info)
+	for _, m := range mounts {
+		if filepath.Dir(m.Mountpoint) != filepath.Clean(d) {
+			continue
+		}
+		volumes[filepath.Base(m.Mountpoint)] = m
+	}
+
+	return volumes, nil
+}
+
+// Helper function to determine if a directory is empty, ignoring the given
+// names. A directory which can't be read is not empty.
+func EmptyDir(d string, ignore ...string) bool {
+	files, err := ioutil.ReadDir(d)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if !Contains(ignore, f.Name()) {
+			return false
+		}
+	}
+	return true
+}